@@ -0,0 +1,300 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/23233/sv"
+	"github.com/go-playground/validator/v10"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 此文件主要放请求体解析相关 支持form与json
+
+// FieldErrors 字段解析错误 key为列名 value为错误信息
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, k+": "+e[k])
+	}
+	return strings.Join(s, "; ")
+}
+
+// validatorMiddleware 与sv.Run一致的验证中间件 验证失败时按字段返回422
+// 请求体允许被多次读取 验证之后处理方法仍能解析json
+func (c *RestApi) validatorMiddleware(valid interface{}) iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.RecordRequestBody(true)
+		s := reflect.TypeOf(valid).Elem()
+		v := reflect.New(s).Interface()
+		var err error
+		if ctx.Method() == "GET" {
+			err = ctx.ReadQuery(v)
+		} else {
+			contentType := ctx.GetContentTypeRequested()
+			if contentType == "application/x-www-form-urlencoded" || strings.HasPrefix(contentType, "multipart/form-data") {
+				err = ctx.ReadForm(v)
+			} else if contentType == "application/xml" {
+				err = ctx.ReadXML(v)
+			} else {
+				err = ctx.ReadJSON(v)
+			}
+		}
+		if err != nil {
+			c.sendError(ctx, bodyError(err))
+			return
+		}
+		if err = validStruct(v); err != nil {
+			c.sendError(ctx, bodyError(err))
+			return
+		}
+		ctx.Values().Set(sv.GlobalContextKey, v)
+		ctx.Next()
+	}
+}
+
+// validStruct 使用sv的验证器验证 tag错误转换为以json名称为key的FieldErrors
+func validStruct(v interface{}) error {
+	err := sv.GlobalValidator.Validate.Struct(v)
+	if err != nil {
+		errs, ok := err.(validator.ValidationErrors)
+		if !ok {
+			return err
+		}
+		t := reflect.TypeOf(v).Elem()
+		fields := make(FieldErrors, len(errs))
+		for _, fe := range errs {
+			fields[validFieldName(t, fe)] = fe.Translate(sv.GlobalValidator.Trans)
+		}
+		return fields
+	}
+	if check, ok := v.(sv.CanCheck); ok {
+		return check.Check()
+	}
+	return nil
+}
+
+// validFieldName 验证失败的字段名 优先使用json tag
+func validFieldName(t reflect.Type, fe validator.FieldError) string {
+	if f, ok := t.FieldByName(fe.StructField()); ok {
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(name) >= 1 && name != "-" {
+			return name
+		}
+	}
+	return fe.StructField()
+}
+
+// isJsonRequest 请求体是否为json
+func isJsonRequest(ctx iris.Context) bool {
+	contentType := ctx.GetContentTypeRequested()
+	return contentType == "application/json" || strings.HasSuffix(contentType, "+json")
+}
+
+// isJsonColumn xorm tag中是否声明为json列
+func isJsonColumn(xormTag string) bool {
+	for _, t := range strings.Fields(xormTag) {
+		if strings.EqualFold(t, "json") || strings.EqualFold(t, "jsonb") {
+			return true
+		}
+	}
+	return false
+}
+
+// columnField 获取列对应的字段 具名的嵌套结构体展开的字段FieldByName无法直接获取 需要逐层查找
+func columnField(v reflect.Value, name string) reflect.Value {
+	v = reflect.Indirect(v)
+	if v.Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	if fv := v.FieldByName(name); fv.IsValid() {
+		return fv
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if !nestedStructField(t.Field(i)) {
+			continue
+		}
+		if fv := columnField(v.Field(i), name); fv.IsValid() {
+			return fv
+		}
+	}
+	return reflect.Value{}
+}
+
+// columnStructField 与columnField一致 按类型查找
+func columnStructField(t reflect.Type, name string) (reflect.StructField, bool) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if f, ok := t.FieldByName(name); ok {
+		return f, true
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if !nestedStructField(t.Field(i)) {
+			continue
+		}
+		if f, ok := columnStructField(t.Field(i).Type, name); ok {
+			return f, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// nestedStructField 是否为展开为多列的嵌套结构体 时间与json列除外
+func nestedStructField(f reflect.StructField) bool {
+	return f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) && !isJsonColumn(f.Tag.Get("xorm"))
+}
+
+// writableColumns 可以由请求写入的列 排除自增 创建 更新 删除时间
+func writableColumns(fields tableFieldsResp) []structInfo {
+	result := make([]structInfo, 0, len(fields.Fields))
+	for _, column := range fields.Fields {
+		if column.MapName == fields.AutoIncrement {
+			continue
+		}
+		if column.MapName == fields.Updated || column.MapName == fields.Deleted {
+			continue
+		}
+		if _, ok := fields.Created[column.MapName]; ok {
+			continue
+		}
+		result = append(result, column)
+	}
+	return result
+}
+
+// setPrivateValue 把私密参数写入实例的私密字段 字段类型不支持时返回false
+func (c *SingleModel) setPrivateValue(instance reflect.Value, value interface{}) bool {
+	private := columnField(instance, c.privateMapName)
+	if !private.IsValid() {
+		return false
+	}
+	pv := fmt.Sprintf("%v", value)
+	switch private.Type().String() {
+	case "string":
+		private.SetString(pv)
+	case "int", "int8", "int16", "int32", "int64", "time.Duration":
+		i, _ := strconv.Atoi(pv)
+		private.SetInt(int64(i))
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		i, _ := strconv.Atoi(pv)
+		private.SetUint(uint64(i))
+	default:
+		return false
+	}
+	return true
+}
+
+// setFormValue 把form中的字符串按字段类型写入
+// 非基础类型(json列等)时内容按json解析
+func setFormValue(fv reflect.Value, types string, content string) error {
+	if fv.Kind() == reflect.Ptr {
+		n := reflect.New(fv.Type().Elem())
+		if err := setFormValue(n.Elem(), strings.TrimPrefix(types, "*"), content); err != nil {
+			return err
+		}
+		fv.Set(n)
+		return nil
+	}
+	if fv.Type() == reflect.TypeOf(time.Time{}) {
+		t, err := parseTime(content)
+		if err != nil {
+			return errors.New("解析出time出错")
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(content)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		d, err := strconv.ParseInt(content, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("解析出int出错")
+		}
+		fv.SetInt(d)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		d, err := strconv.ParseUint(content, 10, fv.Type().Bits())
+		if err != nil {
+			return errors.New("解析出uint出错")
+		}
+		fv.SetUint(d)
+	case reflect.Float32, reflect.Float64:
+		d, err := strconv.ParseFloat(content, fv.Type().Bits())
+		if err != nil {
+			return errors.New("解析出float出错")
+		}
+		fv.SetFloat(d)
+	case reflect.Bool:
+		d, err := parseBool(content)
+		if err != nil {
+			return errors.New("解析出bool出错")
+		}
+		fv.SetBool(d)
+	default:
+		return setJsonValue(fv, jsoniter.RawMessage(content))
+	}
+	return nil
+}
+
+// setJsonValue 把json值按字段类型写入 null会写入零值
+// 时间支持字符串与unix时间戳 数字与bool也接受字符串形式
+func setJsonValue(fv reflect.Value, raw jsoniter.RawMessage) error {
+	raw = jsoniter.RawMessage(strings.TrimSpace(string(raw)))
+	if string(raw) == "null" {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+	if fv.Kind() == reflect.Ptr {
+		n := reflect.New(fv.Type().Elem())
+		if err := setJsonValue(n.Elem(), raw); err != nil {
+			return err
+		}
+		fv.Set(n)
+		return nil
+	}
+	isTime := fv.Type() == reflect.TypeOf(time.Time{})
+	isString := len(raw) >= 1 && raw[0] == '"'
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		if isString {
+			var s string
+			if err := jsoniter.Unmarshal(raw, &s); err != nil {
+				return errors.Errorf("需要%s类型", fv.Type().String())
+			}
+			return setFormValue(fv, fv.Type().String(), s)
+		}
+	}
+	if isTime {
+		var s string
+		if isString {
+			if err := jsoniter.Unmarshal(raw, &s); err != nil {
+				return errors.New("解析出time出错")
+			}
+		} else {
+			s = string(raw)
+		}
+		return setFormValue(fv, "time.Time", s)
+	}
+	target := reflect.New(fv.Type())
+	if err := jsoniter.Unmarshal(raw, target.Interface()); err != nil {
+		return errors.Errorf("需要%s类型", fv.Type().String())
+	}
+	fv.Set(target.Elem())
+	return nil
+}
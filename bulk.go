@@ -0,0 +1,620 @@
+package ab
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/23233/sv"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"io"
+	"reflect"
+	"strconv"
+	"time"
+	"xorm.io/xorm"
+	"xorm.io/xorm/schemas"
+)
+
+// 此文件主要放批量操作相关
+
+// bulkResult 批量操作单条结果
+type bulkResult struct {
+	Index int         `json:"index"`           // 请求中的下标
+	Data  interface{} `json:"data,omitempty"`  // 成功时为新增后的数据
+	Error iris.Map    `json:"error,omitempty"` // 失败时的错误 与错误返回格式一致
+}
+
+// isNdjsonRequest 请求体是否为ndjson 每行一个json对象
+func isNdjsonRequest(ctx iris.Context) bool {
+	contentType := ctx.GetContentTypeRequested()
+	return contentType == "application/x-ndjson" || contentType == "application/ndjson" || contentType == "application/jsonl"
+}
+
+// bulkBodyReader 限制批量请求体的字节数 超过时返回413 不需要先读取完整的请求体
+type bulkBodyReader struct {
+	r    io.Reader
+	left int64 // 剩余可读取的字节数
+}
+
+func (b *bulkBodyReader) Read(p []byte) (int, error) {
+	if b.left <= 0 {
+		// 已到上限 还能读取到数据说明超过限制
+		var one [1]byte
+		n, err := b.r.Read(one[:])
+		if n >= 1 {
+			return 0, bulkSizeError("超过批量请求体大小限制")
+		}
+		return 0, err
+	}
+	if int64(len(p)) > b.left {
+		p = p[:b.left]
+	}
+	n, err := b.r.Read(p)
+	b.left -= int64(n)
+	return n, err
+}
+
+// bulkSizeError 超过批量数量或请求体大小限制
+func bulkSizeError(message string) *ApiError {
+	return NewApiError(iris.StatusRequestEntityTooLarge, CodeBulkSizeFail, message, nil)
+}
+
+// parseBulkBody 边读取边解析批量请求体 支持json数组与ndjson 超过max条时立即报错
+func parseBulkBody(body io.Reader, ndjson bool, max int) ([]jsoniter.RawMessage, error) {
+	var items []jsoniter.RawMessage
+	var err error
+	if ndjson {
+		items, err = parseNdjsonBody(body, max)
+	} else {
+		items, err = parseJsonArrayBody(body, max)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(items) < 1 {
+		return nil, errors.New("没有需要新增的数据")
+	}
+	return items, nil
+}
+
+// parseNdjsonBody 逐行读取 跳过空行
+func parseNdjsonBody(body io.Reader, max int) ([]jsoniter.RawMessage, error) {
+	var items []jsoniter.RawMessage
+	r := bufio.NewReader(body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) >= 1 {
+			if len(items) >= max {
+				return nil, bulkSizeError("超过单次批量数量限制")
+			}
+			items = append(items, jsoniter.RawMessage(line))
+		}
+		if err == io.EOF {
+			return items, nil
+		}
+	}
+}
+
+// parseJsonArrayBody 逐个读取json数组中的元素
+func parseJsonArrayBody(body io.Reader, max int) ([]jsoniter.RawMessage, error) {
+	var items []jsoniter.RawMessage
+	dec := json.NewDecoder(body)
+	var arrayError = func(err error) error {
+		if e, ok := err.(*ApiError); ok {
+			return e
+		}
+		return errors.Wrap(err, "需要json数组")
+	}
+	t, err := dec.Token()
+	if err != nil {
+		return nil, arrayError(err)
+	}
+	if d, ok := t.(json.Delim); !ok || d != '[' {
+		return nil, errors.New("需要json数组")
+	}
+	for dec.More() {
+		if len(items) >= max {
+			return nil, bulkSizeError("超过单次批量数量限制")
+		}
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			return nil, arrayError(err)
+		}
+		items = append(items, jsoniter.RawMessage(item))
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, arrayError(err)
+	}
+	return items, nil
+}
+
+// bulkItem 解析单条数据 依次进行验证器验证 字段解析 私密字段写入 数据转换
+func (c *RestApi) bulkItem(ctx iris.Context, model *SingleModel, raw jsoniter.RawMessage) (interface{}, error) {
+	var body map[string]jsoniter.RawMessage
+	if err := jsoniter.Unmarshal(raw, &body); err != nil {
+		return nil, bodyError(errors.Wrap(err, "需要json对象"))
+	}
+	if model.PostValidator != nil {
+		v := reflect.New(reflect.TypeOf(model.PostValidator).Elem()).Interface()
+		if err := jsoniter.Unmarshal(raw, v); err != nil {
+			return nil, bodyError(err)
+		}
+		if err := validStruct(v); err != nil {
+			return nil, bodyError(err)
+		}
+		// 与单条新增一致 PostDataParse中可以获取到验证器内容
+		ctx.Values().Set(sv.GlobalContextKey, v)
+	}
+	newInstance, _, err := c.jsonInstance(model, body)
+	if err != nil {
+		return nil, bodyError(err)
+	}
+	if model.private {
+		if !model.setPrivateValue(newInstance, ctx.Values().Get(model.PrivateContextKey)) {
+			return nil, NewApiError(iris.StatusInternalServerError, CodePrivateParseFail, "私密参数解析错误", nil)
+		}
+	}
+	singleData := newInstance.Interface()
+	if model.PostDataParse != nil {
+		singleData = model.PostDataParse(ctx, singleData)
+	}
+	return singleData, nil
+}
+
+// bulkResp 新增成功后的返回内容 与单条新增一致
+func (c *RestApi) bulkResp(ctx iris.Context, model *SingleModel, singleData interface{}) interface{} {
+	if model.postResp.Has {
+		n := c.newType(model.postResp.Instance)
+		_ = Replace(singleData, n)
+		singleData = n
+	}
+	if model.PostResponseFunc != nil {
+		singleData = model.PostResponseFunc(ctx, singleData)
+	}
+	return singleData
+}
+
+// BulkAddData 批量新增 /_bulk 请求体为json数组或ndjson
+// 默认全部成功或全部失败 BulkBestEffort时跳过失败的条目 均返回每条的结果
+func (c *RestApi) BulkAddData(ctx iris.Context) {
+	model := c.ctxGetModel(ctx)
+	reader := &bulkBodyReader{r: ctx.Request().Body, left: model.getMaxBulkBodySize()}
+	items, err := parseBulkBody(reader, isNdjsonRequest(ctx), model.getMaxBulkSize())
+	if err != nil {
+		c.sendError(ctx, toApiError(err))
+		return
+	}
+
+	results := make([]bulkResult, len(items))
+	dataList := make([]interface{}, len(items))
+	var firstErr *ApiError
+	var fail int
+	var setFail = func(i int, err error) {
+		e := toApiError(err)
+		if e.Status >= iris.StatusInternalServerError && e.Err != nil {
+			c.C.ErrorTrace(e.Err, e.Code, "bulk", ctx.Path())
+		}
+		if firstErr == nil {
+			firstErr = e
+		}
+		results[i] = bulkResult{Index: i, Error: e.resp(ctx)}
+		fail += 1
+	}
+
+	for i, item := range items {
+		singleData, err := c.bulkItem(ctx, model, item)
+		if err != nil {
+			setFail(i, err)
+			continue
+		}
+		dataList[i] = singleData
+	}
+
+	// 全部成功或全部失败时 存在解析失败的条目则不写入
+	if !model.BulkBestEffort && fail >= 1 {
+		c.sendBulkError(ctx, firstErr, results, fail)
+		return
+	}
+
+	if model.BulkBestEffort {
+		c.bulkInsertBestEffort(model, dataList, setFail)
+	} else {
+		// 全部写入在同一个事务中 每BulkTxSize条一条多行insert 任意一条失败则回滚
+		_, err = c.C.Mdb.Transaction(func(session *xorm.Session) (interface{}, error) {
+			return nil, c.bulkInsertRows(session, model, dataList)
+		})
+		if err != nil {
+			// 回滚后在新的事务中逐条写入 找出失败的条目
+			model.clearAutoIncrement(dataList)
+			_, err = c.C.Mdb.Transaction(func(session *xorm.Session) (interface{}, error) {
+				for i, singleData := range dataList {
+					if err := bulkInsertOne(session, model, singleData); err != nil {
+						setFail(i, err)
+						return nil, err
+					}
+				}
+				return nil, nil
+			})
+		}
+		if err != nil {
+			if firstErr == nil {
+				// 提交事务失败
+				firstErr = storageError(CodeAddDataFail, "新增数据失败", err)
+				c.C.ErrorTrace(err, CodeAddDataFail, "bulk", ctx.Path())
+			}
+			c.sendBulkError(ctx, firstErr, results, fail)
+			return
+		}
+	}
+
+	if fail < len(items) {
+		c.invalidateList(model, ctx.Values().Get(model.PrivateContextKey))
+		c.invalidateNotFound(model, ctx.Values().Get(model.PrivateContextKey))
+	}
+
+	for i, singleData := range dataList {
+		if singleData == nil {
+			continue
+		}
+		results[i] = bulkResult{Index: i, Data: c.bulkResp(ctx, model, singleData)}
+	}
+	body, _ := json.Marshal(iris.Map{
+		"success": len(items) - fail,
+		"fail":    fail,
+		"results": results,
+	})
+	writeJson(ctx, body)
+}
+
+// bulkInsertOne 写入一条数据
+func bulkInsertOne(session xorm.Interface, model *SingleModel, singleData interface{}) error {
+	aff, err := session.Table(model.info.MapName).InsertOne(singleData)
+	if err == nil && aff < 1 {
+		err = errors.New("新增数据失败")
+	}
+	if err != nil {
+		return storageError(CodeAddDataFail, "新增数据失败", err)
+	}
+	return nil
+}
+
+// bulkInsertRows 每BulkTxSize条使用一条多行insert写入 跳过dataList中为nil的条目
+// 失败时只返回错误 需要逐条重试才能知道具体失败的条目
+func (c *RestApi) bulkInsertRows(session *xorm.Session, model *SingleModel, dataList []interface{}) error {
+	txSize := model.getBulkTxSize()
+	list := make([]interface{}, 0, txSize)
+	for i, singleData := range dataList {
+		if singleData != nil {
+			list = append(list, singleData)
+		}
+		if len(list) < 1 || (len(list) < txSize && i < len(dataList)-1) {
+			continue
+		}
+		if err := c.bulkInsertBatch(session, model, list); err != nil {
+			return err
+		}
+		list = list[:0]
+	}
+	return nil
+}
+
+// bulkInsertBatch 使用一条多行insert写入 多行insert不会回填自增主键 写入后按最后插入的主键推算
+// 只有一条 类型不一致 设置了自增主键 或数据库不支持推算自增主键时逐条写入
+func (c *RestApi) bulkInsertBatch(session *xorm.Session, model *SingleModel, list []interface{}) error {
+	autoIncr := model.info.FieldList.AutoIncrement
+	query := c.lastInsertIdSql(len(list))
+	rows, ok := model.bulkRows(list)
+	if !ok || (len(autoIncr) >= 1 && len(query) < 1) {
+		for _, singleData := range list {
+			if err := bulkInsertOne(session, model, singleData); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	aff, err := session.Table(model.info.MapName).Insert(rows)
+	if err == nil && aff < int64(len(list)) {
+		err = errors.New("新增数据失败")
+	}
+	if err != nil {
+		return storageError(CodeAddDataFail, "新增数据失败", err)
+	}
+	if len(autoIncr) < 1 {
+		return nil
+	}
+
+	result, err := session.QueryString(query)
+	if err == nil && len(result) < 1 {
+		err = errors.New("获取自增主键失败")
+	}
+	var first, step int64
+	if err == nil {
+		first, err = strconv.ParseInt(result[0]["first_id"], 10, 64)
+	}
+	if err == nil {
+		step, err = strconv.ParseInt(result[0]["step"], 10, 64)
+	}
+	if err != nil {
+		return storageError(CodeAddDataFail, "获取自增主键失败", err)
+	}
+	for i, singleData := range list {
+		v, _ := modelFieldValue(singleData, model.info.FieldList.Fields, autoIncr)
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			v.SetInt(first + int64(i)*step)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			v.SetUint(uint64(first + int64(i)*step))
+		}
+	}
+	return nil
+}
+
+// lastInsertIdSql 获取多行insert第一条自增主键与步长的sql 需要在同一个连接中执行 不支持的数据库返回空
+// mysql的LAST_INSERT_ID为第一条的主键 按auto_increment_increment递增 sqlite的last_insert_rowid为最后一条的主键
+func (c *RestApi) lastInsertIdSql(n int) string {
+	switch c.C.Mdb.Dialect().URI().DBType {
+	case schemas.MYSQL:
+		return "SELECT LAST_INSERT_ID() AS first_id, @@auto_increment_increment AS step"
+	case schemas.SQLITE:
+		return fmt.Sprintf("SELECT last_insert_rowid() - %d AS first_id, 1 AS step", n-1)
+	}
+	return ""
+}
+
+// bulkRows 把数据转为多行insert使用的切片 需要多于一条 均为同一类型的指针且没有设置自增主键
+func (c *SingleModel) bulkRows(list []interface{}) (interface{}, bool) {
+	if len(list) < 2 {
+		return nil, false
+	}
+	t := reflect.TypeOf(list[0])
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	rows := reflect.MakeSlice(reflect.SliceOf(t), 0, len(list))
+	for _, singleData := range list {
+		if reflect.TypeOf(singleData) != t {
+			return nil, false
+		}
+		if v, ok := modelFieldValue(singleData, c.info.FieldList.Fields, c.info.FieldList.AutoIncrement); ok && !v.IsZero() {
+			return nil, false
+		}
+		rows = reflect.Append(rows, reflect.ValueOf(singleData))
+	}
+	return rows.Interface(), true
+}
+
+// clearAutoIncrement 事务回滚后清空已回填的自增主键 否则重试时会以回滚前的值写入
+func (c *SingleModel) clearAutoIncrement(dataList []interface{}) {
+	for _, singleData := range dataList {
+		if singleData == nil {
+			continue
+		}
+		if v, ok := modelFieldValue(singleData, c.info.FieldList.Fields, c.info.FieldList.AutoIncrement); ok && v.CanSet() {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	}
+}
+
+// bulkInsertBestEffort 跳过失败条目的写入 每BulkTxSize条在一个事务中使用多行insert写入
+// 事务中有失败时回滚 再不使用事务逐条写入找出失败的条目 写入失败的条目在dataList中置为nil
+func (c *RestApi) bulkInsertBestEffort(model *SingleModel, dataList []interface{}, setFail func(int, error)) {
+	txSize := model.getBulkTxSize()
+	for start := 0; start < len(dataList); start += txSize {
+		end := start + txSize
+		if end > len(dataList) {
+			end = len(dataList)
+		}
+		_, err := c.C.Mdb.Transaction(func(session *xorm.Session) (interface{}, error) {
+			return nil, c.bulkInsertRows(session, model, dataList[start:end])
+		})
+		if err == nil {
+			continue
+		}
+		model.clearAutoIncrement(dataList[start:end])
+		for i := start; i < end; i++ {
+			if dataList[i] == nil {
+				continue
+			}
+			if err := bulkInsertOne(c.C.Mdb, model, dataList[i]); err != nil {
+				setFail(i, err)
+				dataList[i] = nil
+			}
+		}
+	}
+}
+
+// sendBulkError 全部成功或全部失败模式下的错误返回 状态码为第一条失败的状态码
+func (c *RestApi) sendBulkError(ctx iris.Context, first *ApiError, results []bulkResult, fail int) {
+	failList := make([]bulkResult, 0, fail)
+	for _, r := range results {
+		if r.Error != nil {
+			failList = append(failList, r)
+		}
+	}
+	e := NewApiError(first.Status, CodeBulkFail, "批量新增失败", nil)
+	resp := e.resp(ctx)
+	resp["success"] = 0
+	resp["fail"] = fail
+	resp["results"] = failList
+	body, _ := json.Marshal(resp)
+	ctx.StatusCode(e.Status)
+	writeJson(ctx, body)
+}
+
+// bulkWhere 批量修改与删除的条件 从url中解析过滤条件 ids为逗号分隔的主键
+// 没有任何条件时返回错误 避免误操作整表
+func (c *RestApi) bulkWhere(ctx iris.Context, model *SingleModel) (func(session *xorm.Session) *xorm.Session, error) {
+	filterList, orList, err := filterMatch(ctx.URLParams(), model.info.FieldList.Fields, model.filterOps)
+	if err != nil {
+		return nil, err
+	}
+	if raw := ctx.URLParam("ids"); len(raw) >= 1 {
+		pk := model.info.FieldList.PrimaryKey
+		if len(pk) < 1 {
+			return nil, errors.New("ids需要单一主键")
+		}
+		for _, field := range model.info.FieldList.Fields {
+			if field.MapName == pk {
+				item, err := parseFilterItem(field, "in", raw)
+				if err != nil {
+					return nil, err
+				}
+				filterList = append(filterList, item)
+				break
+			}
+		}
+	}
+	query, args := filterSql(filterList, orList)
+	if len(query) < 1 {
+		return nil, errors.New("批量操作需要过滤条件")
+	}
+	privateValue := ctx.Values().Get(model.PrivateContextKey)
+
+	return func(session *xorm.Session) *xorm.Session {
+		d := session.Table(model.info.MapName)
+		// 始终限定在私密参数范围内
+		if model.private {
+			d = d.Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue)
+		}
+		if len(model.info.FieldList.Deleted) >= 1 {
+			d = d.Where(fmt.Sprintf("`%s` = ? OR `%s` IS NULL", model.info.FieldList.Deleted, model.info.FieldList.Deleted), "0001-01-01 00:00:00")
+		}
+		for k, v := range model.GetAllExtraFilters {
+			d = d.Where(fmt.Sprintf("`%s` = ?", k), v)
+		}
+		return d.And(query, args...)
+	}, nil
+}
+
+// bulkCheckAffected 检查本次操作影响的条数是否超过限制
+func bulkCheckAffected(session *xorm.Session, model *SingleModel, where func(session *xorm.Session) *xorm.Session) (int64, error) {
+	count, err := where(session).Count()
+	if err != nil {
+		return 0, storageError(CodeGetListCountFail, "获取总数量发生错误", err)
+	}
+	if count > int64(model.getMaxBulkAffected()) {
+		e := NewApiError(iris.StatusBadRequest, CodeBulkLimitFail, "超过批量操作的最大条数", nil)
+		e.Detail = fmt.Sprintf("affected %d over limit %d", count, model.getMaxBulkAffected())
+		return 0, e
+	}
+	return count, nil
+}
+
+// updateMap 把实例中需要更新的列转为map json列转为字符串
+// 批量更新不能使用结构体 xorm会以结构体中的版本号作为条件
+func updateMap(instance interface{}, fields []structInfo, cols []string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(cols))
+	for _, field := range fields {
+		if !isContain(cols, field.MapName) {
+			continue
+		}
+		fv, ok := modelFieldValue(instance, fields, field.MapName)
+		if !ok {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr && fv.IsNil() {
+			result[field.MapName] = nil
+			continue
+		}
+		if isJsonColumn(field.XormTags) {
+			b, err := jsoniter.Marshal(fv.Interface())
+			if err != nil {
+				return nil, err
+			}
+			result[field.MapName] = string(b)
+			continue
+		}
+		result[field.MapName] = reflect.Indirect(fv).Interface()
+	}
+	return result, nil
+}
+
+// BulkEditData 批量部分更新 / 仅更新请求中传递的字段 需要开启AllowBulkEdit
+// 条件为url中的过滤参数或ids 返回影响的条数
+func (c *RestApi) BulkEditData(ctx iris.Context) {
+	model := c.ctxGetModel(ctx)
+	where, err := c.bulkWhere(ctx, model)
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
+	newInstance, cols, err := c.getCtxValues(model.info.MapName, ctx)
+	if err != nil {
+		c.sendError(ctx, bodyError(err))
+		return
+	}
+	// 私密字段不允许修改
+	if model.private {
+		cols = removeItem(cols, model.PrivateColName)
+	}
+	if len(cols) < 1 {
+		c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeNoUpdateColsFail, "没有需要更新的字段", nil))
+		return
+	}
+	data, err := updateMap(newInstance.Interface(), model.info.FieldList.Fields, cols)
+	if err != nil {
+		c.sendError(ctx, bodyError(err))
+		return
+	}
+	if len(model.info.FieldList.Updated) >= 1 {
+		data[model.info.FieldList.Updated] = time.Now()
+	}
+
+	aff, err := c.C.Mdb.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := bulkCheckAffected(session, model, where); err != nil {
+			return nil, err
+		}
+		d := where(session)
+		if len(model.info.FieldList.Version) >= 1 {
+			d = d.Incr(model.info.FieldList.Version)
+		}
+		aff, err := d.Update(data)
+		if err != nil {
+			return nil, storageError(CodeUpdateFail, "更新数据失败", err)
+		}
+		return aff, nil
+	})
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
+	c.invalidateList(model, ctx.Values().Get(model.PrivateContextKey))
+	c.invalidateSingleTag(model, ctx.Values().Get(model.PrivateContextKey))
+
+	_, _ = ctx.JSON(iris.Map{"affected": aff})
+}
+
+// BulkDeleteData 批量删除 / 需要开启AllowBulkDelete
+// 条件为url中的过滤参数或ids 返回影响的条数
+func (c *RestApi) BulkDeleteData(ctx iris.Context) {
+	model := c.ctxGetModel(ctx)
+	where, err := c.bulkWhere(ctx, model)
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
+
+	aff, err := c.C.Mdb.Transaction(func(session *xorm.Session) (interface{}, error) {
+		if _, err := bulkCheckAffected(session, model, where); err != nil {
+			return nil, err
+		}
+		aff, err := where(session).Delete(c.newType(model.Model))
+		if err != nil {
+			return nil, storageError(CodeDeleteFail, "删除数据失败", err)
+		}
+		return aff, nil
+	})
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
+	c.invalidateList(model, ctx.Values().Get(model.PrivateContextKey))
+	c.invalidateSingleTag(model, ctx.Values().Get(model.PrivateContextKey))
+
+	_, _ = ctx.JSON(iris.Map{"affected": aff})
+}
@@ -0,0 +1,261 @@
+package ab
+
+import (
+	"container/list"
+	"context"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 此文件主要放缓存相关 内置redis 进程内存 二级缓存三种实现
+
+// Cache 缓存接口 可以在Config中替换为自己的实现
+type Cache interface {
+	// Get 获取缓存 不存在时返回false
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set 保存缓存 并把key登记到标签中
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration, tags ...string) error
+	// Delete 删除缓存
+	Delete(ctx context.Context, keys ...string) error
+	// DeleteByTag 删除标签下登记的所有缓存
+	DeleteByTag(ctx context.Context, tags ...string) error
+}
+
+// RedisCache redis缓存 标签使用set保存
+type RedisCache struct {
+	Rdb *redis.Client
+}
+
+// NewRedisCache 生成redis缓存
+func NewRedisCache(rdb *redis.Client) *RedisCache {
+	return &RedisCache{Rdb: rdb}
+}
+
+// redisSetScript 保存缓存并登记标签 标签的过期时间只延长不缩短
+var redisSetScript = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+for i = 2, #KEYS do
+	redis.call('SADD', KEYS[i], KEYS[1])
+	if redis.call('PTTL', KEYS[i]) < ttl then
+		redis.call('PEXPIRE', KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+// redisDeleteTagScript 删除标签下所有的key与标签 读取与删除在同一个脚本中 期间登记的key不会丢失
+var redisDeleteTagScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local keys = redis.call('SMEMBERS', KEYS[i])
+	for j = 1, #keys, 1000 do
+		redis.call('DEL', unpack(keys, j, math.min(j + 999, #keys)))
+	end
+	redis.call('DEL', KEYS[i])
+end
+return 1
+`)
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := c.Rdb.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration, tags ...string) error {
+	if len(tags) < 1 {
+		return c.Rdb.Set(ctx, key, value, ttl).Err()
+	}
+	keys := append([]string{key}, tags...)
+	return redisSetScript.Run(ctx, c.Rdb, keys, value, strconv.FormatInt(ttl.Milliseconds(), 10)).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) < 1 {
+		return nil
+	}
+	return c.Rdb.Del(ctx, keys...).Err()
+}
+
+func (c *RedisCache) DeleteByTag(ctx context.Context, tags ...string) error {
+	if len(tags) < 1 {
+		return nil
+	}
+	return redisDeleteTagScript.Run(ctx, c.Rdb, tags).Err()
+}
+
+// memoryItem 内存缓存的条目
+type memoryItem struct {
+	key    string
+	value  []byte
+	expire time.Time
+	tags   []string
+}
+
+// MemoryCache 进程内缓存 超过最大条数时淘汰最久未使用的
+type MemoryCache struct {
+	mu      sync.Mutex
+	max     int
+	ll      *list.List
+	items   map[string]*list.Element
+	tagKeys map[string]map[string]struct{}
+}
+
+// NewMemoryCache 生成进程内缓存 maxEntries为最大条数 default 10000
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries < 1 {
+		maxEntries = 10000
+	}
+	return &MemoryCache{
+		max:     maxEntries,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+		tagKeys: make(map[string]map[string]struct{}),
+	}
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	item := el.Value.(*memoryItem)
+	if time.Now().After(item.expire) {
+		c.remove(el)
+		return nil, false, nil
+	}
+	c.ll.MoveToFront(el)
+	return item.value, true, nil
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	item := &memoryItem{key: key, value: value, expire: time.Now().Add(ttl), tags: tags}
+	c.items[key] = c.ll.PushFront(item)
+	for _, tag := range tags {
+		if _, ok := c.tagKeys[tag]; !ok {
+			c.tagKeys[tag] = make(map[string]struct{})
+		}
+		c.tagKeys[tag][key] = struct{}{}
+	}
+	for c.ll.Len() > c.max {
+		c.remove(c.ll.Back())
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.remove(el)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCache) DeleteByTag(_ context.Context, tags ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for key := range c.tagKeys[tag] {
+			if el, ok := c.items[key]; ok {
+				c.remove(el)
+			}
+		}
+		delete(c.tagKeys, tag)
+	}
+	return nil
+}
+
+// Len 当前缓存条数 包含已过期未清理的
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// remove 删除条目 同时从标签中移除 需要持有锁
+func (c *MemoryCache) remove(el *list.Element) {
+	item := c.ll.Remove(el).(*memoryItem)
+	delete(c.items, item.key)
+	for _, tag := range item.tags {
+		if keys, ok := c.tagKeys[tag]; ok {
+			delete(keys, item.key)
+			if len(keys) < 1 {
+				delete(c.tagKeys, tag)
+			}
+		}
+	}
+}
+
+// TieredCache 二级缓存 L1一般为进程内缓存 L2一般为redis
+// L1的过期时间不超过L1TTL 多实例时其他实例的写入最多延迟L1TTL生效
+type TieredCache struct {
+	L1    Cache
+	L2    Cache
+	L1TTL time.Duration
+}
+
+// tieredFillTag 从L2取回写入L1的缓存使用的标签
+const tieredFillTag = "tag:tiered:fill"
+
+// NewTieredCache 生成二级缓存 l1TTL default 5s
+func NewTieredCache(l1 Cache, l2 Cache, l1TTL time.Duration) *TieredCache {
+	if l1TTL < 1 {
+		l1TTL = 5 * time.Second
+	}
+	return &TieredCache{L1: l1, L2: l2, L1TTL: l1TTL}
+}
+
+// l1TTL L1的过期时间不超过原本的过期时间
+func (c *TieredCache) l1TTL(ttl time.Duration) time.Duration {
+	if ttl > 0 && ttl < c.L1TTL {
+		return ttl
+	}
+	return c.L1TTL
+}
+
+func (c *TieredCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	if b, ok, err := c.L1.Get(ctx, key); err == nil && ok {
+		return b, true, nil
+	}
+	b, ok, err := c.L2.Get(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	// 从L2取回时不知道原本的标签 登记到统一标签 任意标签清除时一并清除
+	_ = c.L1.Set(ctx, key, b, c.L1TTL, tieredFillTag)
+	return b, true, nil
+}
+
+func (c *TieredCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration, tags ...string) error {
+	if err := c.L2.Set(ctx, key, value, ttl, tags...); err != nil {
+		return err
+	}
+	return c.L1.Set(ctx, key, value, c.l1TTL(ttl), tags...)
+}
+
+func (c *TieredCache) Delete(ctx context.Context, keys ...string) error {
+	_ = c.L1.Delete(ctx, keys...)
+	return c.L2.Delete(ctx, keys...)
+}
+
+func (c *TieredCache) DeleteByTag(ctx context.Context, tags ...string) error {
+	_ = c.L1.DeleteByTag(ctx, append(append([]string{}, tags...), tieredFillTag)...)
+	return c.L2.DeleteByTag(ctx, tags...)
+}
@@ -0,0 +1,93 @@
+// ab-gen 根据注册的模型生成typescript类型与请求客户端
+//
+// 需要在项目中提供一个返回*ab.Config或*ab.RestApi的导出方法 eg:
+//
+//	package api
+//	func AbApi() *ab.Config { return &ab.Config{Party: app.Party("/api/v1"), MysqlConfig: ..., Models: ...} }
+//
+// 返回*ab.Config时使用ab.NewOffline 不连接数据库与redis 只需要能创建engine(导入mysql驱动或直接提供未连接的Mdb)
+// 返回*ab.RestApi时由ab.New创建 会连接数据库
+//
+// 在项目模块目录中执行
+//
+//	ab-gen -pkg github.com/me/app/api -func AbApi -out web/src/api.ts
+//
+// 会在当前目录生成临时的main包导入注册包并调用ExportTypeScript 完成后删除
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+)
+
+var mainTemplate = template.Must(template.New("main").Parse(`// Code generated by ab-gen. DO NOT EDIT.
+
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/23233/ab"
+	target {{ printf "%q" .Pkg }}
+)
+
+func main() {
+	var api *ab.RestApi
+	switch v := interface{}(target.{{ .Func }}()).(type) {
+	case *ab.Config:
+		api = ab.NewOffline(v)
+	case *ab.RestApi:
+		api = v
+	default:
+		log.Fatalf("{{ .Func }} need return *ab.Config or *ab.RestApi got %T", v)
+	}
+	if err := api.ExportTypeScript(os.Args[1]); err != nil {
+		log.Fatal(err)
+	}
+}
+`))
+
+func main() {
+	pkg := flag.String("pkg", "", "注册模型的包 import path")
+	fn := flag.String("func", "AbApi", "包中返回*ab.Config或*ab.RestApi的方法名")
+	out := flag.String("out", "ab-client.ts", "输出的typescript文件")
+	flag.Parse()
+	if len(*pkg) < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	output, err := filepath.Abs(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// 临时目录需要在当前模块中 才能导入模块内的包
+	dir, err := ioutil.TempDir(".", "ab-gen-")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "main.go"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = mainTemplate.Execute(f, map[string]string{"Pkg": *pkg, "Func": *fn})
+	_ = f.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", "./"+filepath.Base(dir), output)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err = cmd.Run(); err != nil {
+		_ = os.RemoveAll(dir)
+		log.Fatal(err)
+	}
+	fmt.Println("generated", output)
+}
@@ -0,0 +1,236 @@
+package ab
+
+import (
+	"encoding/base64"
+	"fmt"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"strings"
+	"xorm.io/xorm"
+)
+
+// 此文件主要放游标(keyset)分页相关
+// 游标由排序字段与主键的值组成 使用 where (a,b,id) > (?,?,?) 的方式翻页 不受页码限制
+// 排序字段可以为NULL NULL视为最小值 与mysql sqlite的排序一致
+
+// cursorData 游标内容 base64后对外不透明
+type cursorData struct {
+	Sort   string    `json:"s"`           // 生成游标时的排序 防止换了排序继续使用
+	Values []*string `json:"v"`           // 排序字段对应的值 nil为NULL
+	Prev   bool      `json:"p,omitempty"` // 是否向前翻页
+}
+
+// encodeCursor 生成游标
+func encodeCursor(d cursorData) string {
+	b, _ := jsoniter.Marshal(d)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor 解析游标
+func decodeCursor(s string) (cursorData, error) {
+	var d cursorData
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return d, errors.Wrap(err, "游标解析出错")
+	}
+	err = jsoniter.Unmarshal(b, &d)
+	if err != nil {
+		return d, errors.Wrap(err, "游标解析出错")
+	}
+	return d, nil
+}
+
+// cursorSort 游标分页的排序 末尾追加主键保证顺序唯一
+func cursorSort(sorts []sortItem, pk string) []sortItem {
+	result := make([]sortItem, 0, len(sorts)+1)
+	for _, s := range sorts {
+		if s.Col == pk {
+			return append(result, s)
+		}
+		result = append(result, s)
+	}
+	// 主键方向与最后一个排序字段一致
+	var desc bool
+	if len(sorts) >= 1 {
+		desc = sorts[len(sorts)-1].Desc
+	}
+	return append(result, sortItem{Col: pk, Desc: desc})
+}
+
+// reverseSort 向前翻页时反转排序方向
+func reverseSort(sorts []sortItem) []sortItem {
+	result := make([]sortItem, 0, len(sorts))
+	for _, s := range sorts {
+		result = append(result, sortItem{Col: s.Col, Desc: !s.Desc})
+	}
+	return result
+}
+
+// cursorNullKey 查询中标记排序字段是否为NULL的列名 QueryString无法区分NULL与空字符串
+func cursorNullKey(i int) string {
+	return fmt.Sprintf("_ab_null_%d", i)
+}
+
+// cursorSelect 查询所有列与排序字段是否为NULL
+func cursorSelect(sorts []sortItem) string {
+	result := []string{"*"}
+	for i, s := range sorts {
+		result = append(result, fmt.Sprintf("(`%s` IS NULL) AS `%s`", s.Col, cursorNullKey(i)))
+	}
+	return strings.Join(result, ", ")
+}
+
+// rowCursor 根据行数据生成游标
+func rowCursor(row map[string]string, sorts []sortItem, prev bool) string {
+	values := make([]*string, 0, len(sorts))
+	for i, s := range sorts {
+		if isNull, _ := parseBool(row[cursorNullKey(i)]); isNull {
+			values = append(values, nil)
+			continue
+		}
+		v := row[s.Col]
+		values = append(values, &v)
+	}
+	return encodeCursor(cursorData{Sort: sortToString(sorts), Values: values, Prev: prev})
+}
+
+// cursorSql 生成游标条件 (a > ?) OR (a = ? AND b > ?) OR ...
+// 值为NULL时使用IS NULL比较 NULL视为最小值 倒序时NULL在最后
+// sorts 为实际查询使用的排序 向前翻页时应传入反转后的排序
+func cursorSql(sorts []sortItem, values []interface{}) (string, []interface{}) {
+	orList := make([]string, 0, len(sorts))
+	args := make([]interface{}, 0)
+	for i, s := range sorts {
+		var after string
+		var afterArgs []interface{}
+		switch {
+		case values[i] == nil && s.Desc:
+			// 倒序时NULL之后没有更小的值
+			continue
+		case values[i] == nil:
+			after = fmt.Sprintf("`%s` IS NOT NULL", s.Col)
+		case s.Desc:
+			after = fmt.Sprintf("(`%s` < ? OR `%s` IS NULL)", s.Col, s.Col)
+			afterArgs = []interface{}{values[i]}
+		default:
+			after = fmt.Sprintf("`%s` > ?", s.Col)
+			afterArgs = []interface{}{values[i]}
+		}
+		andList := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			if values[j] == nil {
+				andList = append(andList, fmt.Sprintf("`%s` IS NULL", sorts[j].Col))
+				continue
+			}
+			andList = append(andList, fmt.Sprintf("`%s` = ?", sorts[j].Col))
+			args = append(args, values[j])
+		}
+		andList = append(andList, after)
+		args = append(args, afterArgs...)
+		orList = append(orList, "("+strings.Join(andList, " AND ")+")")
+	}
+	if len(orList) < 1 {
+		return "1 = 0", nil
+	}
+	return "(" + strings.Join(orList, " OR ") + ")", args
+}
+
+// parseCursorValues 校验游标并按字段类型解析值 解析失败时保留原始字符串
+func parseCursorValues(d cursorData, sorts []sortItem, fields []structInfo) ([]interface{}, error) {
+	if d.Sort != sortToString(sorts) || len(d.Values) != len(sorts) {
+		return nil, errors.New("游标与当前排序不匹配")
+	}
+	values := make([]interface{}, 0, len(sorts))
+	for i, s := range sorts {
+		if d.Values[i] == nil {
+			values = append(values, nil)
+			continue
+		}
+		var v interface{} = *d.Values[i]
+		for _, field := range fields {
+			if field.MapName == s.Col {
+				if pv, err := parseFieldValue(field.Types, *d.Values[i]); err == nil {
+					v = pv
+				}
+				break
+			}
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// getCursorList 游标分页获取列表 向result写入next_cursor与prev_cursor
+// 默认会统计总数 count=0时跳过统计
+func (c *RestApi) getCursorList(ctx iris.Context, model *SingleModel, pageSize int, sortList []sortItem, where func() *xorm.Session, result iris.Map) ([]map[string]string, error) {
+	// 单一主键在Run中已校验
+	pk := model.info.FieldList.PrimaryKey
+	sorts := cursorSort(sortList, pk)
+	querySort := sorts
+	var prev, hasCursor bool
+
+	d := where()
+	if raw := ctx.URLParam("cursor"); len(raw) >= 1 {
+		cd, err := decodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+		values, err := parseCursorValues(cd, sorts, model.info.FieldList.Fields)
+		if err != nil {
+			return nil, err
+		}
+		hasCursor = true
+		prev = cd.Prev
+		if prev {
+			querySort = reverseSort(sorts)
+		}
+		query, args := cursorSql(querySort, values)
+		d = d.And(query, args...)
+	}
+
+	// 多取一条判断是否还有数据
+	dataList, err := d.Select(cursorSelect(sorts)).OrderBy(sortToSql(querySort)).Limit(pageSize + 1).QueryString()
+	if err != nil {
+		return nil, storageError(CodeGetListDataFail, "获取内容列表发生错误", err)
+	}
+	// 没有数据时与页码分页一致返回空数组
+	if dataList == nil {
+		dataList = make([]map[string]string, 0)
+	}
+	hasMore := len(dataList) > pageSize
+	if hasMore {
+		dataList = dataList[:pageSize]
+	}
+	if prev {
+		for i, j := 0, len(dataList)-1; i < j; i, j = i+1, j-1 {
+			dataList[i], dataList[j] = dataList[j], dataList[i]
+		}
+	}
+
+	var nextCursor, prevCursor string
+	if len(dataList) >= 1 {
+		if prev || hasMore {
+			nextCursor = rowCursor(dataList[len(dataList)-1], sorts, false)
+		}
+		if (prev && hasMore) || (!prev && hasCursor) {
+			prevCursor = rowCursor(dataList[0], sorts, true)
+		}
+	}
+	result["next_cursor"] = nextCursor
+	result["prev_cursor"] = prevCursor
+	for _, row := range dataList {
+		for i := range sorts {
+			delete(row, cursorNullKey(i))
+		}
+	}
+
+	if withCount, err := parseBool(ctx.URLParamDefault("count", "1")); err != nil || withCount {
+		allCount, err := where().Count()
+		if err != nil {
+			return nil, storageError(CodeGetListCountFail, "获取总数量发生错误", err)
+		}
+		result["all"] = allCount
+	}
+	return dataList, nil
+}
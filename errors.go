@@ -0,0 +1,179 @@
+package ab
+
+import (
+	"encoding/json"
+	"github.com/kataras/iris/v12"
+	"net/http"
+	"strings"
+)
+
+// 此文件主要放错误相关 错误码与locales.ini中的key一致 客户端可通过code判断错误类型
+
+// 错误码
+const (
+	CodeParamsParseFail      = "apiParamsParseFail"      // 请求体解析失败
+	CodeParamsFail           = "apiParamsFail"           // 请求参数错误
+	CodeValidateFail         = "apiValidateFail"         // 字段验证失败
+	CodeNotFoundDataFail     = "apiNotFoundDataFail"     // 数据不存在
+	CodePrivateParseFail     = "apiPrivateParseFail"     // 私密参数解析失败
+	CodeAddDataFail          = "apiAddDataFail"          // 新增失败
+	CodeUpdateFail           = "apiUpdateFail"           // 更新失败
+	CodeDeleteFail           = "apiDeleteFail"           // 删除失败
+	CodeGetListCountFail     = "apiGetListCountFail"     // 获取总数失败
+	CodeGetListDataFail      = "apiGetListDataFail"      // 获取列表失败
+	CodeDataExistsFail       = "apiDataExistsFail"       // 获取数据是否存在失败
+	CodeNoUpdateColsFail     = "apiNoUpdateColsFail"     // 没有需要更新的字段
+	CodePreconditionFail     = "apiPreconditionFail"     // 数据版本不一致
+	CodePreconditionRequired = "apiPreconditionRequired" // 需要携带If-Match
+	CodeUniqueFail           = "apiUniqueFail"           // 唯一约束冲突
+	CodeBulkFail             = "apiBulkFail"             // 批量操作失败
+	CodeBulkSizeFail         = "apiBulkSizeFail"         // 超过批量数量限制
+	CodeBulkLimitFail        = "apiBulkLimitFail"        // 超过批量操作影响的最大条数
+	CodeExpandFail           = "apiExpandFail"           // 加载关联数据失败
+	CodeParentParseFail      = "apiParentParseFail"      // 嵌套路由外键解析失败
+)
+
+// ApiError 接口错误
+type ApiError struct {
+	Status  int         // http状态码
+	Code    string      // 错误码 同时作为翻译的key
+	Message string      // 无翻译时使用的默认信息
+	Detail  string      // 具体的错误说明 为空时使用翻译后的信息
+	Fields  FieldErrors // 字段错误
+	Err     error       // 原始错误 不会返回给客户端
+}
+
+func (e *ApiError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	if len(e.Detail) >= 1 {
+		return e.Code + ": " + e.Detail
+	}
+	return e.Code + ": " + e.Message
+}
+
+func (e *ApiError) Unwrap() error {
+	return e.Err
+}
+
+// NewApiError 生成接口错误
+func NewApiError(status int, code string, message string, err error) *ApiError {
+	return &ApiError{Status: status, Code: code, Message: message, Err: err}
+}
+
+// paramsError 请求参数错误 错误信息会作为detail返回
+func paramsError(err error) *ApiError {
+	e := NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数错误", err)
+	e.Detail = err.Error()
+	return e
+}
+
+// notFoundError 数据不存在
+func notFoundError(err error) *ApiError {
+	return NewApiError(iris.StatusNotFound, CodeNotFoundDataFail, "查询数据失败", err)
+}
+
+// preconditionError 乐观锁校验失败 status为412或428
+func preconditionError(status int) *ApiError {
+	if status == iris.StatusPreconditionRequired {
+		return NewApiError(status, CodePreconditionRequired, "需要携带If-Match", nil)
+	}
+	return NewApiError(status, CodePreconditionFail, "数据版本不一致", nil)
+}
+
+// bodyError 请求内容解析错误 字段错误返回422
+func bodyError(err error) *ApiError {
+	if fields, ok := err.(FieldErrors); ok {
+		e := NewApiError(iris.StatusUnprocessableEntity, CodeValidateFail, "字段验证失败", err)
+		e.Fields = fields
+		return e
+	}
+	e := NewApiError(iris.StatusBadRequest, CodeParamsParseFail, "请求解析出错", err)
+	e.Detail = err.Error()
+	return e
+}
+
+// storageError 数据库错误 唯一约束冲突返回409 其余返回500
+func storageError(code string, message string, err error) *ApiError {
+	if isUniqueError(err) {
+		return NewApiError(iris.StatusConflict, CodeUniqueFail, "数据已存在", err)
+	}
+	return NewApiError(iris.StatusInternalServerError, code, message, err)
+}
+
+// isUniqueError 是否为唯一约束冲突 兼容mysql sqlite postgres
+func isUniqueError(err error) bool {
+	if err == nil {
+		return false
+	}
+	s := err.Error()
+	return strings.Contains(s, "Duplicate entry") || strings.Contains(s, "UNIQUE constraint failed") || strings.Contains(s, "duplicate key value")
+}
+
+// tr 翻译 未加载语言文件或没有对应翻译时返回默认信息
+func tr(ctx iris.Context, key string, message string) (result string) {
+	defer func() {
+		if recover() != nil {
+			result = message
+		}
+	}()
+	result = ctx.Tr(key)
+	if len(result) < 1 || result == key {
+		result = message
+	}
+	return result
+}
+
+// toApiError 非ApiError的错误作为参数错误处理
+func toApiError(err error) *ApiError {
+	if e, ok := err.(*ApiError); ok {
+		return e
+	}
+	if _, isField := err.(FieldErrors); isField {
+		return bodyError(err)
+	}
+	return paramsError(err)
+}
+
+// detail 返回给客户端的说明 为空时使用翻译后的信息
+func (e *ApiError) detail(ctx iris.Context) string {
+	if len(e.Detail) >= 1 {
+		return e.Detail
+	}
+	return tr(ctx, e.Code, e.Message)
+}
+
+// resp 错误返回的内容
+func (e *ApiError) resp(ctx iris.Context) iris.Map {
+	resp := iris.Map{
+		"code":   e.Code,
+		"detail": e.detail(ctx),
+	}
+	if len(e.Fields) >= 1 {
+		resp["fields"] = e.Fields
+	}
+	return resp
+}
+
+// sendError 错误返回 非ApiError的错误作为参数错误处理
+// 请求Accept为application/problem+json或配置了ProblemJson时按RFC 7807返回
+func (c *RestApi) sendError(ctx iris.Context, err error) {
+	e := toApiError(err)
+	if e.Status >= iris.StatusInternalServerError && e.Err != nil {
+		c.C.ErrorTrace(e.Err, e.Code, "handler", ctx.Path())
+	}
+
+	ctx.StatusCode(e.Status)
+	if c.C.ProblemJson || strings.Contains(ctx.GetHeader("Accept"), "application/problem+json") {
+		resp := e.resp(ctx)
+		resp["type"] = "about:blank"
+		resp["title"] = http.StatusText(e.Status)
+		resp["status"] = e.Status
+		body, _ := json.Marshal(resp)
+		ctx.ContentType("application/problem+json")
+		_, _ = ctx.Write(body)
+		return
+	}
+	_, _ = ctx.JSON(e.resp(ctx))
+}
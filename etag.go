@@ -0,0 +1,108 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/kataras/iris/v12"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// 此文件主要放etag相关 乐观锁与条件请求
+
+// versionEtag 根据xorm version列的值生成etag
+func versionEtag(version interface{}) string {
+	return fmt.Sprintf(`"v%v"`, version)
+}
+
+// etagMatch 判断If-Match If-None-Match中是否包含etag 忽略W/弱校验前缀
+func etagMatch(header string, etag string) bool {
+	for _, item := range strings.Split(header, ",") {
+		item = strings.TrimSpace(item)
+		if item == "*" {
+			return true
+		}
+		if strings.TrimPrefix(item, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// modelFieldValue 获取模型实例中某列的反射值
+func modelFieldValue(instance interface{}, fields []structInfo, mapName string) (reflect.Value, bool) {
+	if len(mapName) < 1 {
+		return reflect.Value{}, false
+	}
+	v := reflect.Indirect(reflect.ValueOf(instance))
+	for _, field := range fields {
+		if field.MapName == mapName {
+			fv := columnField(v, field.Name)
+			return fv, fv.IsValid()
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// versionValue 获取实例的版本号 模型没有version列时返回false
+func (c *SingleModel) versionValue(instance interface{}) (reflect.Value, bool) {
+	return modelFieldValue(instance, c.info.FieldList.Fields, c.info.FieldList.Version)
+}
+
+// checkIfMatch 根据当前数据的版本号校验If-Match
+// 返回0为通过 412为版本不一致 428为模型要求必须携带If-Match
+func (c *SingleModel) checkIfMatch(ctx iris.Context, current interface{}) int {
+	version, ok := c.versionValue(current)
+	if !ok {
+		return 0
+	}
+	header := ctx.GetHeader("If-Match")
+	if len(header) < 1 {
+		if c.RequireIfMatch {
+			return iris.StatusPreconditionRequired
+		}
+		return 0
+	}
+	if !etagMatch(header, versionEtag(version.Interface())) {
+		return iris.StatusPreconditionFailed
+	}
+	return 0
+}
+
+// contentEtag 根据响应内容生成强etag
+func contentEtag(body []byte) string {
+	return `"` + genRedisKey(string(body)) + `"`
+}
+
+// updatedValue 获取实例的更新时间 模型没有updated列时返回零值
+func (c *SingleModel) updatedValue(instance interface{}) time.Time {
+	v, ok := modelFieldValue(instance, c.info.FieldList.Fields, c.info.FieldList.Updated)
+	if !ok {
+		return time.Time{}
+	}
+	if t, ok := reflect.Indirect(v).Interface().(time.Time); ok {
+		return t
+	}
+	return time.Time{}
+}
+
+// checkNotModified 写入ETag与Last-Modified 并根据If-None-Match If-Modified-Since判断是否返回304
+// 同时存在时If-None-Match优先 返回true时已经写入304
+func checkNotModified(ctx iris.Context, etag string, lastModified time.Time) bool {
+	if len(etag) >= 1 {
+		ctx.Header("ETag", etag)
+	}
+	ctx.SetLastModified(lastModified)
+	if inm := ctx.GetHeader("If-None-Match"); len(inm) >= 1 {
+		if len(etag) >= 1 && etagMatch(inm, etag) {
+			ctx.WriteNotModified()
+			return true
+		}
+		return false
+	}
+	if modified, err := ctx.CheckIfModifiedSince(lastModified); !modified && err == nil {
+		ctx.WriteNotModified()
+		return true
+	}
+	return false
+}
@@ -3,7 +3,11 @@ package ab
 import "log"
 
 func (c *RestApi) checkConfig() {
-	c.C.MysqlInstance.check()
+	if c.offline {
+		c.C.MysqlInstance.init()
+	} else {
+		c.C.MysqlInstance.check()
+	}
 	hasCache := false
 	for _, model := range c.C.Models {
 		if model.getAllListCacheTime() >= 1 || model.getSingleCacheTime() >= 1 {
@@ -11,8 +15,13 @@ func (c *RestApi) checkConfig() {
 			break
 		}
 	}
-	if hasCache {
+	// 配置了缓存实现时不再需要redis
+	if hasCache && c.C.Cache == nil && !c.offline {
 		c.C.RedisInstance.check()
+		c.C.Cache = NewRedisCache(c.C.Rdb)
+	}
+	if c.C.RateStore == nil {
+		c.C.RateStore = NewMemoryRateStore()
 	}
 	if c.C.ErrorTrace == nil {
 		c.C.ErrorTrace = func(err error, event, from, router string) {
@@ -0,0 +1,260 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// 此文件主要放列表过滤相关解析
+// filter_[字段名]__[操作符]=值 or_[字段名]__[操作符]=值 不带操作符时为eq
+
+// 支持的过滤操作符
+const (
+	opEq         = "eq"
+	opNe         = "ne"
+	opGt         = "gt"
+	opGte        = "gte"
+	opLt         = "lt"
+	opLte        = "lte"
+	opIn         = "in"
+	opNin        = "nin"
+	opIsNull     = "isnull"
+	opContains   = "contains"
+	opStartsWith = "startswith"
+	opEndsWith   = "endswith"
+)
+
+// 操作符对应的sql比较符
+var filterCompare = map[string]string{
+	opEq:  "=",
+	opNe:  "<>",
+	opGt:  ">",
+	opGte: ">=",
+	opLt:  "<",
+	opLte: "<=",
+}
+
+// 允许的过滤操作符 eq不在其中是因为eq可以省略
+var filterOps = []string{opNe, opGt, opGte, opLt, opLte, opIn, opNin, opIsNull, opContains, opStartsWith, opEndsWith}
+
+const (
+	filterMaxLen   = 64  // 单个值最大长度
+	filterMaxInLen = 100 // in nin 最多的值数量
+)
+
+// filterItem 解析后的单个过滤条件
+type filterItem struct {
+	Col   string      // 数据库列名
+	Op    string      // 操作符
+	Raw   string      // 原始值
+	Value interface{} // 根据字段类型解析后的值 in nin为[]interface{} isnull为bool
+}
+
+// key 返回url中的参数形式 eq省略操作符
+func (f filterItem) key() string {
+	if f.Op == opEq {
+		return f.Col
+	}
+	return f.Col + "__" + f.Op
+}
+
+// sql 生成条件语句与参数
+func (f filterItem) sql() (string, []interface{}) {
+	switch f.Op {
+	case opIn, opNin:
+		values := f.Value.([]interface{})
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+		if f.Op == opIn {
+			return fmt.Sprintf("`%s` IN (%s)", f.Col, marks), values
+		}
+		return fmt.Sprintf("`%s` NOT IN (%s)", f.Col, marks), values
+	case opIsNull:
+		if f.Value.(bool) {
+			return fmt.Sprintf("`%s` IS NULL", f.Col), nil
+		}
+		return fmt.Sprintf("`%s` IS NOT NULL", f.Col), nil
+	case opContains:
+		return likeSql(f.Col), []interface{}{"%" + escapeLike(f.Raw) + "%"}
+	case opStartsWith:
+		return likeSql(f.Col), []interface{}{escapeLike(f.Raw) + "%"}
+	case opEndsWith:
+		return likeSql(f.Col), []interface{}{"%" + escapeLike(f.Raw)}
+	}
+	return fmt.Sprintf("`%s` %s ?", f.Col, filterCompare[f.Op]), []interface{}{f.Value}
+}
+
+// likeSql 生成like语句 统一使用!作为转义符 兼容mysql与sqlite
+func likeSql(col string) string {
+	return fmt.Sprintf("`%s` LIKE ? ESCAPE '!'", col)
+}
+
+// escapeLike 转义like中的通配符
+func escapeLike(s string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(s)
+}
+
+// typeAllowOp 字段类型是否支持该操作符
+func typeAllowOp(types string, op string) bool {
+	types = strings.TrimPrefix(types, "*")
+	switch op {
+	case opEq, opNe, opIn, opNin, opIsNull:
+		return true
+	case opContains, opStartsWith, opEndsWith:
+		return types == "string"
+	}
+	// 大小比较 bool不支持
+	return types != "bool"
+}
+
+// parseFilterKey 拆分出列名与操作符 列名未带操作符时为eq 也可以显式使用__eq
+func parseFilterKey(k string) (string, string) {
+	i := strings.LastIndex(k, "__")
+	if i > 0 {
+		op := k[i+2:]
+		if op == opEq || isContain(filterOps, op) {
+			return k[:i], op
+		}
+	}
+	return k, opEq
+}
+
+// parseFilterItem 根据字段类型解析单个过滤条件
+func parseFilterItem(field structInfo, op string, raw string) (filterItem, error) {
+	item := filterItem{Col: field.MapName, Op: op, Raw: raw}
+	if !typeAllowOp(field.Types, op) {
+		return item, errors.Errorf("字段%s类型不支持%s过滤", field.MapName, op)
+	}
+	switch op {
+	case opIn, opNin:
+		parts := strings.Split(raw, ",")
+		if len(parts) > filterMaxInLen {
+			return item, errors.Errorf("字段%s的%s过滤最多%d个值", field.MapName, op, filterMaxInLen)
+		}
+		values := make([]interface{}, 0, len(parts))
+		for _, p := range parts {
+			v, err := parseFieldValue(field.Types, strings.Trim(p, " "))
+			if err != nil {
+				return item, errors.Wrapf(err, "字段%s过滤值解析出错", field.MapName)
+			}
+			values = append(values, v)
+		}
+		item.Value = values
+	case opIsNull:
+		v, err := parseBool(raw)
+		if err != nil {
+			return item, errors.Wrapf(err, "字段%s过滤值解析出错", field.MapName)
+		}
+		item.Value = v
+	case opContains, opStartsWith, opEndsWith:
+		item.Value = raw
+	default:
+		v, err := parseFieldValue(field.Types, raw)
+		if err != nil {
+			return item, errors.Wrapf(err, "字段%s过滤值解析出错", field.MapName)
+		}
+		item.Value = v
+	}
+	return item, nil
+}
+
+// filterMatch 从url参数中解析出and与or过滤条件
+// allowOps 为列名对应允许的操作符 未配置的列仅允许eq
+// 未知的列会被忽略 已知的列操作符不被允许或值解析失败时返回错误
+func filterMatch(fullParams map[string]string, fields []structInfo, allowOps map[string][]string) ([]filterItem, []filterItem, error) {
+	filter := make([]filterItem, 0)
+	or := make([]filterItem, 0)
+	for k, v := range fullParams {
+		var name string
+		var isOr bool
+		if strings.HasPrefix(k, "filter_") {
+			name = strings.Replace(k, "filter_", "", 1)
+		} else if strings.HasPrefix(k, "or_") {
+			name = strings.Replace(k, "or_", "", 1)
+			isOr = true
+		} else {
+			continue
+		}
+		col, op := parseFilterKey(name)
+		for _, field := range fields {
+			if field.MapName != col {
+				continue
+			}
+			// 为了安全 长度限制一下 in nin 限制的是单个值
+			v = strings.Trim(v, " ")
+			if op != opIn && op != opNin && len(v) > filterMaxLen {
+				break
+			}
+			if op != opEq && !isContain(allowOps[col], op) {
+				return nil, nil, errors.Errorf("字段%s不允许使用%s过滤", col, op)
+			}
+			item, err := parseFilterItem(field, op, v)
+			if err != nil {
+				return nil, nil, err
+			}
+			if isOr {
+				or = append(or, item)
+			} else {
+				filter = append(filter, item)
+			}
+			break
+		}
+	}
+	return filter, or, nil
+}
+
+// filterSql 合并过滤条件 (and条件) OR or条件 整体加括号 与私密条件等组合时不会因优先级越权
+func filterSql(filterList []filterItem, orList []filterItem) (string, []interface{}) {
+	andSql := make([]string, 0, len(filterList))
+	args := make([]interface{}, 0)
+	for _, f := range filterList {
+		query, a := f.sql()
+		andSql = append(andSql, query)
+		args = append(args, a...)
+	}
+	orSql := make([]string, 0, len(orList)+1)
+	if len(andSql) >= 1 {
+		orSql = append(orSql, "("+strings.Join(andSql, " AND ")+")")
+	}
+	for _, f := range orList {
+		query, a := f.sql()
+		orSql = append(orSql, "("+query+")")
+		args = append(args, a...)
+	}
+	if len(orSql) < 1 {
+		return "", nil
+	}
+	return "(" + strings.Join(orSql, " OR ") + ")", args
+}
+
+// filterToMap 过滤条件转换为url形式的map 用于返回
+func filterToMap(items []filterItem) map[string]string {
+	m := make(map[string]string, len(items))
+	for _, item := range items {
+		m[item.key()] = item.Raw
+	}
+	return m
+}
+
+// parseAllowFilterOps 把模型配置的操作符转换为列名对应 *代表所有字段
+func parseAllowFilterOps(setting map[string][]string, fields []structInfo) map[string][]string {
+	result := make(map[string][]string, 0)
+	if len(setting) < 1 {
+		return result
+	}
+	for _, field := range fields {
+		if ops, ok := setting[field.Name]; ok {
+			result[field.MapName] = ops
+			continue
+		}
+		if ops, ok := setting[field.MapName]; ok {
+			result[field.MapName] = ops
+			continue
+		}
+		if ops, ok := setting["*"]; ok {
+			result[field.MapName] = ops
+		}
+	}
+	return result
+}
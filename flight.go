@@ -0,0 +1,127 @@
+package ab
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/go-redis/redis/v8"
+	"sync"
+	"time"
+)
+
+// 此文件主要放缓存击穿相关 同一个key同时只有一个请求回源
+
+// cacheLockTime 分布式锁的过期时间 回源超过此时间时其他实例也会回源
+const cacheLockTime = 10 * time.Second
+
+// Locker 分布式锁 RedisCache实现了此接口 Config.CacheLock开启时用于多实例合并回源
+type Locker interface {
+	// Lock 获取锁 成功时返回解锁方法
+	Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error)
+}
+
+// flightGroup 进程内同一个key的请求合并
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]chan struct{}
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{m: make(map[string]chan struct{})}
+}
+
+// join 加入key的请求 第一个加入的为leader 需要调用done
+// 其余的返回leader完成时关闭的chan
+func (g *flightGroup) join(key string) (bool, <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ch, ok := g.m[key]; ok {
+		return false, ch
+	}
+	ch := make(chan struct{})
+	g.m[key] = ch
+	return true, ch
+}
+
+// done leader完成 唤醒等待的请求
+func (g *flightGroup) done(key string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ch, ok := g.m[key]; ok {
+		close(ch)
+		delete(g.m, key)
+	}
+}
+
+// redisUnlockScript 仅删除自己持有的锁
+var redisUnlockScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (c *RedisCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	token := hex.EncodeToString(b)
+	lockKey := "lock:" + key
+	ok, err := c.Rdb.SetNX(ctx, lockKey, token, ttl).Result()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	return func() {
+		_ = redisUnlockScript.Run(context.Background(), c.Rdb, []string{lockKey}, token).Err()
+	}, true, nil
+}
+
+// Lock 二级缓存使用L2的锁 L2未实现时视为获取成功
+func (c *TieredCache) Lock(ctx context.Context, key string, ttl time.Duration) (func(), bool, error) {
+	if locker, ok := c.L2.(Locker); ok {
+		return locker.Lock(ctx, key, ttl)
+	}
+	return func() {}, true, nil
+}
+
+// lockCache 开启CacheLock且缓存实现了Locker时获取分布式锁 否则视为获取成功
+func (c *RestApi) lockCache(ctx context.Context, key string) (func(), bool) {
+	locker, ok := c.C.Cache.(Locker)
+	if !c.C.CacheLock || !ok {
+		return func() {}, true
+	}
+	unlock, ok, err := locker.Lock(ctx, key, cacheLockTime)
+	if err != nil {
+		// 锁出错时直接回源
+		c.C.ErrorTrace(err, "lock", "cache", key)
+		return func() {}, true
+	}
+	return unlock, ok
+}
+
+// tryRefresh 数据过期后尝试成为刷新的请求 失败时说明已经有请求在刷新
+func (c *RestApi) tryRefresh(ctx context.Context, key string) (func(), bool) {
+	leader, _ := c.flight.join(key)
+	if !leader {
+		return nil, false
+	}
+	unlock, ok := c.lockCache(ctx, key)
+	if !ok {
+		c.flight.done(key)
+		return nil, false
+	}
+	return func() {
+		unlock()
+		c.flight.done(key)
+	}, true
+}
+
+// waitCache 未命中时等待其他请求回源 超时或请求结束时返回
+func waitCache(ctx context.Context, ch <-chan struct{}) {
+	t := time.NewTimer(cacheLockTime)
+	defer t.Stop()
+	select {
+	case <-ch:
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -1,44 +1,33 @@
 package ab
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	jsoniter "github.com/json-iterator/go"
 	"github.com/kataras/iris/v12"
-	"github.com/kataras/iris/v12/sessions/sessiondb/redis"
 	"github.com/pkg/errors"
-	"strconv"
+	"net/http"
 	"strings"
 	"time"
 	"xorm.io/xorm"
 )
 
-// 错误返回
-func fastError(err error, ctx iris.Context, msg ...string) {
-	ctx.StatusCode(iris.StatusBadRequest)
-	var m string
-	if err == nil {
-		m = ctx.Tr("apiParamsParseFail", "请求解析出错")
-	} else {
-		m = err.Error()
-	}
-	if len(msg) >= 1 {
-		m = msg[0]
-	}
-	_, _ = ctx.JSON(iris.Map{
-		"detail": m,
-	})
-	return
-}
-
 // GetAllFunc 获取所有
 // page控制页码 page_size控制条数 最大均为100 100页 100条
-// order(asc) order_desc
-// search搜索 __会被替换为% eg:search=__赵日天 sql会替换为 %赵日天
+// CursorPage启用时使用cursor翻页 返回next_cursor prev_cursor count=0跳过总数统计
+// sort多字段排序 eg:sort=-created,name,+id -为倒序 字段需在AllowSortFields中
+// order(asc) order_desc 仍然兼容 同样会校验字段
+// search搜索 search_mode指定模式 exact prefix suffix contains fulltext
+// 未指定模式时兼容__写法 eg:search=__赵日天 为后缀匹配 %赵日天
 // filter_[字段名] 进行过滤 eg:filter_id=1 and的关系
 // or_[字段名] 进行过滤 eg:or_id=2 or的关系
+// filter_[字段名]__[操作符] 需在AllowFilterOps中允许 eg:filter_age__gte=18 filter_status__in=a,b
+// 操作符 ne gt gte lt lte in nin isnull contains startswith endswith
+// expand=author,tags 加载Relations中配置的关联 每个关联一次查询
 // 使用header的Cache-control no-cache 跳过缓存
+// 返回ETag 携带If-None-Match且未变化时返回304
 func (c *RestApi) GetAllFunc(ctx iris.Context) {
-	model := c.pathGetModel(ctx.Path())
+	model := c.ctxGetModel(ctx)
 	page := ctx.URLParamIntDefault("page", 1)
 	maxCount, maxSize := model.getPage()
 	if page > maxCount {
@@ -49,34 +38,66 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 		pageSize = maxSize
 	}
 
-	// 解析出order by
+	// 解析出order by sort优先 兼容order与order_desc
+	sortStr := ctx.URLParam("sort")
 	descField := ctx.URLParam("order_desc")
 	orderBy := ctx.URLParam("order")
+	var sortRaw string
+	if len(sortStr) >= 1 {
+		sortRaw = sortStr
+	} else if len(orderBy) >= 1 {
+		sortRaw = orderBy
+	} else if len(descField) >= 1 {
+		sortRaw = "-" + descField
+	}
+	sortList, err := parseSort(sortRaw, model.sortFields)
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
 	// 从url中解析出filter
-	filterList, orList := filterMatch(ctx.URLParams(), model.info.FieldList.Fields)
+	filterList, orList, err := filterMatch(ctx.URLParams(), model.info.FieldList.Fields, model.filterOps)
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
 
 	// 如果必传参数存在
 	if len(model.GetAllMustFilters) > 0 {
+		filterMap := filterToMap(filterList)
 		for k := range model.GetAllMustFilters {
-			if _, ok := filterList[k]; !ok {
-				fastError(nil, ctx, ctx.Tr("apiParamsFail", "参数错误"))
+			if _, ok := filterMap[k]; !ok {
+				c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数错误", nil))
 				return
 			}
 		}
 	}
 
+	relations, err := model.parseExpand(ctx.URLParam("expand"))
+	if err != nil {
+		c.sendError(ctx, err)
+		return
+	}
+
 	searchStr := ctx.URLParam("search")
-	search := strings.ReplaceAll(searchStr, "__", "%")
+	search := searchStr
+	var searchMode string
 	if len(search) >= 1 {
 		if len(model.searchFields) < 1 {
-			fastError(errors.New("搜索功能未启用"), ctx)
+			c.sendError(ctx, errors.New("搜索功能未启用"))
+			return
+		}
+		searchMode, search, err = model.getSearchMode(ctx.URLParam("search_mode"), search)
+		if err != nil {
+			c.sendError(ctx, err)
 			return
 		}
 	}
 
 	privateValue := ctx.Values().Get(model.PrivateContextKey)
 	start := (page - 1) * pageSize
-	end := page * (pageSize * 2)
+
+	parent := ctxParent(ctx)
 
 	var base = func() *xorm.Session {
 		var d *xorm.Session
@@ -84,12 +105,7 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 		if model.private {
 			d = d.Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue)
 		}
-		if len(orderBy) >= 1 {
-			d = d.OrderBy(orderBy)
-		} else if len(descField) >= 1 {
-			d = d.Desc(descField)
-		}
-		return d
+		return parent.where(d)
 	}
 
 	where := func() *xorm.Session {
@@ -98,16 +114,9 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 		if len(model.info.FieldList.Deleted) >= 1 {
 			d = base().Where(fmt.Sprintf("`%s` = ? OR `%s` IS NULL", model.info.FieldList.Deleted, model.info.FieldList.Deleted), "0001-01-01 00:00:00")
 		}
-		if len(filterList) >= 1 {
-			for k, v := range filterList {
-				d = d.Where(fmt.Sprintf("`%s` = ?", k), v)
-			}
-		}
-		// or
-		if len(orList) >= 1 {
-			for k, v := range orList {
-				d = d.Or(fmt.Sprintf("`%s` = ?", k), v)
-			}
+		// or条件与and条件整体作为一个条件 不影响私密条件
+		if query, args := filterSql(filterList, orList); len(query) >= 1 {
+			d = d.And(query, args...)
 		}
 
 		// 额外附加字段
@@ -117,34 +126,59 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 			}
 		}
 		if len(search) >= 1 {
-			searchSql := make([]string, 0, len(model.searchFields))
-			for _, s := range model.searchFields {
-				searchSql = append(searchSql, fmt.Sprintf("`%s` like '%s'", s, search))
-			}
-			d = d.Where(strings.Join(searchSql, " or "))
+			query, args := searchSql(searchMode, model.searchFields, search)
+			d = d.Where(query, args...)
 		}
 		return d
 	}
 
-	// 获取总数量
-	allCount, err := where().Count()
-	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiGetListCountFail", "获取总数量发生错误"))
-		return
+	result := iris.Map{
+		"page_size": pageSize,
 	}
-
-	// 获取内容
 	dataList := make([]map[string]string, 0)
-	if allCount >= 1 {
-		// 简单解决深度翻页性能问题
-		// 如果存在自增且且是软删除并且不包含其他筛选条件
-		if len(model.info.FieldList.AutoIncrement) >= 1 && len(model.info.FieldList.Version) >= 1 && len(filterList) < 1 && len(orderBy) < 1 && len(descField) < 1 && len(search) < 1 {
-			dataList, err = where().And(fmt.Sprintf("%s between ? and ?", model.info.FieldList.AutoIncrement), start, end).Limit(pageSize).QueryString()
-		} else {
-			dataList, err = where().Limit(pageSize, start).QueryString()
+
+	if model.CursorPage {
+		// 游标分页 不受页码限制
+		dataList, err = c.getCursorList(ctx, model, pageSize, sortList, where, result)
+		if err != nil {
+			c.sendError(ctx, err)
+			return
 		}
+	} else {
+		// 获取总数量
+		allCount, err := where().Count()
 		if err != nil {
-			fastError(err, ctx, ctx.Tr("apiGetListDataFail", "获取内容列表发生错误"))
+			c.sendError(ctx, storageError(CodeGetListCountFail, "获取总数量发生错误", err))
+			return
+		}
+
+		// 获取内容
+		if allCount >= 1 {
+			// 深度翻页使用游标分页 CursorPage
+			if len(sortList) >= 1 {
+				dataList, err = where().OrderBy(sortToSql(sortList)).Limit(pageSize, start).QueryString()
+			} else {
+				dataList, err = where().Limit(pageSize, start).QueryString()
+			}
+			if err != nil {
+				c.sendError(ctx, storageError(CodeGetListDataFail, "获取内容列表发生错误", err))
+				return
+			}
+		}
+		result["page"] = page
+		result["all"] = allCount
+	}
+
+	// 加载关联 需要在转换返回值之前 转换后可能不包含外键
+	var expanded []map[string]interface{}
+	if len(relations) >= 1 {
+		rows := make([]interface{}, 0, len(dataList))
+		for _, item := range dataList {
+			rows = append(rows, item)
+		}
+		expanded, err = c.expandRelations(ctx, model, relations, rows)
+		if err != nil {
+			c.sendError(ctx, expandError(err))
 			return
 		}
 	}
@@ -168,26 +202,37 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 		dataList = r
 	}
 
-	result := iris.Map{
-		"page_size": pageSize,
-		"page":      page,
-		"all":       allCount,
-		"data":      dataList,
-	}
-	if len(descField) >= 1 {
-		result["desc_field"] = descField
+	result["data"] = dataList
+	if len(relations) >= 1 {
+		data := make([]map[string]interface{}, 0, len(dataList))
+		for i, item := range dataList {
+			row := make(map[string]interface{}, len(item)+len(relations))
+			for k, v := range item {
+				row[k] = v
+			}
+			for k, v := range expanded[i] {
+				row[k] = v
+			}
+			data = append(data, row)
+		}
+		result["data"] = data
 	}
-	if len(orderBy) >= 1 {
+	if len(sortStr) >= 1 {
+		result["sort"] = sortToString(sortList)
+	} else if len(orderBy) >= 1 {
 		result["order"] = orderBy
+	} else if len(descField) >= 1 {
+		result["desc_field"] = descField
 	}
 	if len(filterList) >= 1 {
-		result["filter"] = filterList
+		result["filter"] = filterToMap(filterList)
 	}
 	if len(orList) >= 1 {
-		result["or"] = orList
+		result["or"] = filterToMap(orList)
 	}
 	if len(search) >= 1 {
 		result["search"] = searchStr
+		result["search_mode"] = searchMode
 	}
 
 	// 如果需要自定义返回 把数据内容传过去
@@ -195,41 +240,42 @@ func (c *RestApi) GetAllFunc(ctx iris.Context) {
 		result = model.GetAllResponseFunc(ctx, result, dataList)
 	}
 
-	// 如果启用了缓存
-	if model.getAllListCacheTime() >= 1 {
-
-		// 生成key
-		rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue), model.getAllExtraParams())
-		// 保存结果
-		resp, err := jsoniter.MarshalToString(result)
-		if err != nil {
-			c.C.ErrorTrace(err, "json_marshal", "json", "get(all)")
-		}
-		err = c.saveToRedis(ctx.Request().Context(), rKey, resp, model.getAllListCacheTime())
-		if err != nil {
-			c.C.ErrorTrace(err, "save_to_redis", "redis", "get(all)")
-		}
+	resp, err := json.Marshal(result)
+	if err != nil {
+		c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodeGetListDataFail, "获取内容列表发生错误", err))
+		return
 	}
 
-	_, _ = ctx.JSON(result)
+	// 内容未变化返回304
+	if checkNotModified(ctx, contentEtag(resp), time.Time{}) {
+		return
+	}
+	writeJson(ctx, resp)
 }
 
-// GetSingle 单个 /{id:uint64}
+// GetSingle 单个 /{id:uint64} expand=author 加载关联
+// 返回ETag与Last-Modified 支持If-None-Match If-Modified-Since 未变化时返回304
 func (c *RestApi) GetSingle(ctx iris.Context) {
 	id, err := ctx.Params().GetUint64("id")
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiParamsFail", "参数错误"))
+		c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数错误", err))
+		return
+	}
+	model := c.ctxGetModel(ctx)
+	relations, err := model.parseExpand(ctx.URLParam("expand"))
+	if err != nil {
+		c.sendError(ctx, err)
 		return
 	}
-	model := c.pathGetModel(ctx.Path())
 	privateValue := ctx.Values().Get(model.PrivateContextKey)
 	newData := c.newType(model.Model)
+	parent := ctxParent(ctx)
 
 	var base = func() *xorm.Session {
 		if model.private {
-			return c.C.Mdb.Table(newData).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue)
+			return parent.where(c.C.Mdb.Table(newData).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue))
 		}
-		return c.C.Mdb.Table(newData)
+		return parent.where(c.C.Mdb.Table(newData))
 	}
 
 	where := func() *xorm.Session {
@@ -245,11 +291,34 @@ func (c *RestApi) GetSingle(ctx iris.Context) {
 	}
 
 	has, err := where().ID(id).Get(newData)
-	if err != nil || has == false {
-		fastError(err, ctx, ctx.Tr("apiNotFoundDataFail", "查询数据失败"))
+	if err != nil {
+		c.sendError(ctx, storageError(CodeNotFoundDataFail, "查询数据失败", err))
+		return
+	}
+	if has == false {
+		c.sendError(ctx, notFoundError(nil))
 		return
 	}
 
+	// 存在版本号时etag使用版本号 用于If-Match 否则使用内容生成
+	var etag string
+	if version, ok := model.versionValue(newData); ok {
+		etag = versionEtag(version.Interface())
+	}
+	lastModified := model.updatedValue(newData)
+
+	// 加载关联 关联数据变化时版本号与更新时间不变 使用内容生成etag
+	var expanded map[string]interface{}
+	if len(relations) >= 1 {
+		extra, err := c.expandRelations(ctx, model, relations, []interface{}{newData})
+		if err != nil {
+			c.sendError(ctx, expandError(err))
+			return
+		}
+		expanded = extra[0]
+		etag, lastModified = "", time.Time{}
+	}
+
 	// 需要转换返回值
 	if model.singleResp.Has {
 		n := c.newType(model.singleResp.Instance)
@@ -261,54 +330,48 @@ func (c *RestApi) GetSingle(ctx iris.Context) {
 		newData = model.GetSingleResponseFunc(ctx, newData)
 	}
 
-	// 如果启用了缓存
-	if model.getSingleCacheTime() >= 1 {
-		// 生成key
-		rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue), model.getSingleExtraParams())
-		// 保存结果
-		resp, err := jsoniter.MarshalToString(newData)
-		if err != nil {
-			c.C.ErrorTrace(err, "json_marshal", "json", "get(single)")
-		}
-		err = c.saveToRedis(ctx.Request().Context(), rKey, resp, model.getSingleCacheTime())
+	resp, err := json.Marshal(newData)
+	if err != nil {
+		c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodeNotFoundDataFail, "查询数据失败", err))
+		return
+	}
+	if expanded != nil {
+		resp, err = mergeJsonObject(resp, expanded)
 		if err != nil {
-			c.C.ErrorTrace(err, "save_to_redis", "redis", "get(single)")
-
+			c.sendError(ctx, expandError(err))
+			return
 		}
 	}
+	if len(etag) < 1 {
+		etag = contentEtag(resp)
+	}
 
-	_, _ = ctx.JSON(newData)
+	// 内容未变化返回304
+	if checkNotModified(ctx, etag, lastModified) {
+		return
+	}
+	writeJson(ctx, resp)
 }
 
 // AddData 新增数据
 func (c *RestApi) AddData(ctx iris.Context) {
-	model := c.pathGetModel(ctx.Path())
-	newInstance, err := c.getCtxValues(model.info.MapName, ctx)
+	model := c.ctxGetModel(ctx)
+	newInstance, _, err := c.getCtxValues(model.info.MapName, ctx)
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiParamsFail", "获取请求内容出错"))
+		c.sendError(ctx, bodyError(err))
 		return
 	}
 	if model.private {
-		privateName := ctx.Values().Get(model.PrivateContextKey)
-		private := newInstance.Elem().FieldByName(model.privateMapName)
-		c := fmt.Sprintf("%v", privateName)
-		switch private.Type().String() {
-		case "string":
-			private.SetString(c)
-			break
-		case "int", "int8", "int16", "int32", "int64", "time.Duration":
-			i, _ := strconv.Atoi(c)
-			private.SetInt(int64(i))
-			break
-		case "uint", "uint8", "uint16", "uint32", "uint64":
-			i, _ := strconv.Atoi(c)
-			private.SetUint(uint64(i))
-			break
-		default:
-			fastError(err, ctx, ctx.Tr("apiPrivateParseFail", "私密参数解析错误"))
+		if !model.setPrivateValue(newInstance, ctx.Values().Get(model.PrivateContextKey)) {
+			c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodePrivateParseFail, "私密参数解析错误", nil))
 			return
 		}
 	}
+	// 嵌套路由外键使用父数据主键
+	if !model.setParentValue(newInstance, ctxParent(ctx)) {
+		c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodeParentParseFail, "父数据参数解析错误", nil))
+		return
+	}
 
 	singleData := newInstance.Interface()
 
@@ -319,9 +382,11 @@ func (c *RestApi) AddData(ctx iris.Context) {
 
 	aff, err := c.C.Mdb.Table(model.info.MapName).InsertOne(singleData)
 	if err != nil || aff == 0 {
-		fastError(err, ctx, ctx.Tr("apiAddDataFail", "新增数据失败"))
+		c.sendError(ctx, storageError(CodeAddDataFail, "新增数据失败", err))
 		return
 	}
+	c.invalidateList(model, ctx.Values().Get(model.PrivateContextKey))
+	c.invalidateNotFound(model, ctx.Values().Get(model.PrivateContextKey))
 
 	// 需要转换返回值
 	if model.postResp.Has {
@@ -338,85 +403,128 @@ func (c *RestApi) AddData(ctx iris.Context) {
 	_, _ = ctx.JSON(singleData)
 }
 
-// EditData 编辑数据 /{id:uint64}
+// EditData 编辑数据 /{id:uint64} 全量更新 未传的字段会被更新为零值
 func (c *RestApi) EditData(ctx iris.Context) {
-	model := c.pathGetModel(ctx.Path())
+	c.updateData(ctx, false)
+}
+
+// PatchData 部分更新 /{id:uint64} 仅更新请求中传递的字段
+func (c *RestApi) PatchData(ctx iris.Context) {
+	c.updateData(ctx, true)
+}
+
+// updateData 更新数据 partial为true时仅更新请求中存在的列
+func (c *RestApi) updateData(ctx iris.Context, partial bool) {
+	model := c.ctxGetModel(ctx)
 	privateValue := ctx.Values().Get(model.PrivateContextKey)
 	id, err := ctx.Params().GetUint64("id")
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiGetListCountFail", "参数获取错误"))
+		c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数获取错误", err))
 		return
 	}
 
+	parent := ctxParent(ctx)
+
 	var base = func() *xorm.Session {
 		if model.private {
-			return c.C.Mdb.Table(model.info.MapName).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue)
+			return parent.where(c.C.Mdb.Table(model.info.MapName).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue))
 		}
-		return c.C.Mdb.Table(model.info.MapName)
+		return parent.where(c.C.Mdb.Table(model.info.MapName))
 	}
 	// 先获取数据是否存在
-	has, err := base().Where("id = ?", id).Exist()
+	current := c.newType(model.Model)
+	has, err := base().ID(id).Get(current)
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiDataExistsFail", "获取数据是否存在发生错误"))
+		c.sendError(ctx, storageError(CodeDataExistsFail, "获取数据是否存在发生错误", err))
 		return
 	}
 	if has != true {
-		fastError(err, ctx, ctx.Tr("apiNotFoundDataFail", "查询数据失败"))
+		c.sendError(ctx, notFoundError(nil))
 		return
 	}
-	newInstance, err := c.getCtxValues(model.info.MapName, ctx)
+	// 乐观锁校验
+	if status := model.checkIfMatch(ctx, current); status != 0 {
+		c.sendError(ctx, preconditionError(status))
+		return
+	}
+	newInstance, cols, err := c.getCtxValues(model.info.MapName, ctx)
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiParamsFail", "获取请求内容出错"))
+		c.sendError(ctx, bodyError(err))
 		return
 	}
 
-	if model.private {
-		private := newInstance.Elem().FieldByName(model.privateMapName)
-		c := fmt.Sprintf("%v", privateValue)
-		switch private.Type().String() {
-		case "string":
-			private.SetString(c)
-			break
-		case "int", "int8", "int16", "int32", "int64", "time.Duration":
-			i, _ := strconv.Atoi(c)
-			private.SetInt(int64(i))
-			break
-		case "uint", "uint8", "uint16", "uint32", "uint64":
-			i, _ := strconv.Atoi(c)
-			private.SetUint(uint64(i))
-			break
-		default:
-			fastError(err, ctx, ctx.Tr("apiPrivateParseFail", "私密参数解析错误"))
+	if partial {
+		// 私密字段不允许修改
+		if model.private {
+			cols = removeItem(cols, model.PrivateColName)
+		}
+		// 嵌套路由的外键不允许修改
+		if parent != nil {
+			cols = removeItem(cols, parent.Col)
+		}
+		if len(cols) < 1 {
+			c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeNoUpdateColsFail, "没有需要更新的字段", nil))
+			return
+		}
+	} else {
+		if model.private && !model.setPrivateValue(newInstance, privateValue) {
+			c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodePrivateParseFail, "私密参数解析错误", nil))
+			return
+		}
+		if !model.setParentValue(newInstance, parent) {
+			c.sendError(ctx, NewApiError(iris.StatusInternalServerError, CodeParentParseFail, "父数据参数解析错误", nil))
 			return
 		}
 	}
 
 	// 更新之前先删除一次key
 	if model.getSingleCacheTime() >= 1 {
-		// 删除缓存
-		rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue))
-		err := c.deleteToRedis(ctx.Request().Context(), rKey)
+		err := c.deleteCache(ctx.Request().Context(), model.singleKey(id, privateValue))
 		if err != nil {
 			c.C.ErrorTrace(err, "delete", "redis", "edit")
 		}
 	}
 
-	// 全量更新
 	singleData := newInstance.Interface()
-	aff, err := c.C.Mdb.Table(model.info.MapName).ID(id).AllCols().Update(singleData)
+	// 版本号使用当前数据的 xorm会以此作为更新条件并自增
+	currentVersion, hasVersion := model.versionValue(current)
+	if hasVersion {
+		if v, ok := model.versionValue(singleData); ok {
+			v.Set(currentVersion)
+		}
+	}
+	var aff int64
+	if partial {
+		aff, err = base().ID(id).Cols(cols...).Update(singleData)
+	} else {
+		// 全量更新
+		aff, err = c.C.Mdb.Table(model.info.MapName).ID(id).AllCols().Update(singleData)
+	}
+	if err == nil && aff < 1 && hasVersion {
+		// 查询之后数据被其他请求修改了
+		c.sendError(ctx, preconditionError(iris.StatusPreconditionFailed))
+		return
+	}
 	if err != nil || aff < 1 {
-		fastError(err, ctx, ctx.Tr("apiUpdateFail", "更新数据失败"))
+		c.sendError(ctx, storageError(CodeUpdateFail, "更新数据失败", err))
 		return
 	}
 
+	c.invalidateList(model, privateValue)
 	// 再次删除缓存 双删确保安全
-	if model.getSingleCacheTime() >= 1 {
-		go func() {
-			time.Sleep(model.getDelayDeleteTime())
-			// 再次删除缓存 不保证结果
-			rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue))
-			_ = c.deleteToRedis(ctx.Request().Context(), rKey)
-		}()
+	c.invalidateSingle(model, id, privateValue)
+
+	// 部分更新返回更新后的完整数据
+	if partial {
+		singleData = c.newType(model.Model)
+		_, err = base().ID(id).Get(singleData)
+		if err != nil {
+			c.sendError(ctx, storageError(CodeNotFoundDataFail, "查询数据失败", err))
+			return
+		}
+	}
+	if version, ok := model.versionValue(singleData); ok {
+		ctx.Header("ETag", versionEtag(version.Interface()))
 	}
 
 	// 需要转换返回值
@@ -433,48 +541,56 @@ func (c *RestApi) EditData(ctx iris.Context) {
 // DeleteData 删除数据 /{id:uint64}
 func (c *RestApi) DeleteData(ctx iris.Context) {
 	// 先获取
-	model := c.pathGetModel(ctx.Path())
+	model := c.ctxGetModel(ctx)
 	privateValue := ctx.Values().Get(model.PrivateContextKey)
 	id, err := ctx.Params().GetUint64("id")
 	newData := c.newType(model.Model)
 
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiParamsFail", "获取参数错误"))
+		c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "获取参数错误", err))
 		return
 	}
+	parent := ctxParent(ctx)
 	var base = func() *xorm.Session {
 		if model.private {
-			return c.C.Mdb.Table(newData).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue)
+			return parent.where(c.C.Mdb.Table(newData).Where(fmt.Sprintf("%s = ?", model.PrivateColName), privateValue))
 		}
-		return c.C.Mdb.Table(newData)
+		return parent.where(c.C.Mdb.Table(newData))
 	}
 	// 先获取数据是否存在
 	has, err := base().ID(id).Get(newData)
 	if err != nil {
-		fastError(err, ctx, ctx.Tr("apiDataExistsFail", "获取数据是否存在发生错误"))
+		c.sendError(ctx, storageError(CodeDataExistsFail, "获取数据是否存在发生错误", err))
 		return
 	}
 	if has != true {
-		fastError(err, ctx, ctx.Tr("apiNotFoundData", "获取数据失败"))
+		c.sendError(ctx, notFoundError(err))
+		return
+	}
+	// 乐观锁校验
+	if status := model.checkIfMatch(ctx, newData); status != 0 {
+		c.sendError(ctx, preconditionError(status))
+		return
+	}
+	// 进行删除 存在版本号时以版本号作为条件
+	del := base().ID(id)
+	version, hasVersion := model.versionValue(newData)
+	if hasVersion {
+		del = del.Where(fmt.Sprintf("`%s` = ?", model.info.FieldList.Version), version.Interface())
+	}
+	aff, err := del.Delete(newData)
+	if err == nil && aff < 1 && hasVersion {
+		c.sendError(ctx, preconditionError(iris.StatusPreconditionFailed))
 		return
 	}
-	// 进行删除
-	aff, err := base().ID(id).Delete(newData)
 	if err != nil || aff < 1 {
-		fastError(err, ctx, ctx.Tr("apiDeleteFail", "删除数据失败"))
+		c.sendError(ctx, storageError(CodeDeleteFail, "删除数据失败", err))
 		return
 	}
 
+	c.invalidateList(model, privateValue)
 	// 删除key
-	if model.getSingleCacheTime() >= 1 {
-		// 删除缓存
-		rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue))
-		err := c.deleteToRedis(ctx.Request().Context(), rKey)
-		if err != nil {
-			c.C.ErrorTrace(err, "redis_delete", "redis", "delete")
-
-		}
-	}
+	c.invalidateSingle(model, id, privateValue)
 
 	// 需要转换返回值
 	if model.deleteResp.Has {
@@ -487,44 +603,182 @@ func (c *RestApi) DeleteData(ctx iris.Context) {
 
 }
 
-// 获取数据的中间件
+// 获取数据的中间件 原样缓存响应体与Content-Type ETag Last-Modified
+// 未命中时同一个key只有一个请求回源 其余等待后读取缓存
+// 过期但在StaleTime内时由一个请求刷新 其余返回旧数据
 func (c *RestApi) getCacheMiddleware(from string) iris.Handler {
 	return func(ctx iris.Context) {
-		model := c.pathGetModel(ctx.Path())
+		model := c.ctxGetModel(ctx)
 		// 判断header中 Cache-control
+		// 关联数据的修改不会清除缓存 加载关联时不使用缓存
 		cacheHeader := ctx.GetHeader("Cache-control")
-		if cacheHeader == "no-cache" {
+		if cacheHeader == "no-cache" || len(ctx.URLParam("expand")) >= 1 {
+			c.setCacheStatus(ctx, "MISS")
 			ctx.Next()
 			return
 		}
 		privateValue := ctx.Values().Get(model.PrivateContextKey)
-		var extraParams string
+		// 获取参数 生成key
+		var rKey, tag string
+		var expire time.Duration
 		if from == "list" {
-			extraParams = model.getAllExtraParams()
+			rKey = genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue), model.getAllExtraParams())
+			tag = model.listTag(privateValue)
+			expire = model.getAllListCacheTime()
 		} else {
-			extraParams = model.getSingleExtraParams()
+			id, err := ctx.Params().GetUint64("id")
+			if err != nil {
+				ctx.Next()
+				return
+			}
+			rKey = model.singleKey(id, privateValue)
+			tag = model.singleTag(privateValue)
+			expire = model.getSingleCacheTime()
 		}
-		// 获取参数 生成key
-		rKey := genRedisKey(ctx.Request().RequestURI, model.PrivateColName, fmt.Sprintf("%v", privateValue), extraParams)
+		reqCtx := ctx.Request().Context()
+		// 回源并保存结果 登记到标签 写入时按标签清除
+		var next = func() {
+			c.setCacheStatus(ctx, "MISS")
+			ctx.Record()
+			ctx.Next()
+			rec := ctx.Recorder()
+			if !c.cacheable(ctx, model, from) {
+				return
+			}
+			// 数据不存在时短时间缓存 只记录状态码 返回时重新生成错误内容
+			if rec.StatusCode() == iris.StatusNotFound {
+				e := cacheEntry{Status: iris.StatusNotFound}
+				if err := c.saveToCache(reqCtx, rKey, e, model.NotFoundCacheTime, 0, tag, model.notFoundTag(privateValue)); err != nil {
+					c.C.ErrorTrace(err, "save_to_cache", "cache", from)
+				}
+				return
+			}
+			e := cacheEntry{
+				ContentType:  rec.Header().Get("Content-Type"),
+				Etag:         rec.Header().Get("ETag"),
+				LastModified: rec.Header().Get("Last-Modified"),
+				Body:         append([]byte{}, rec.Body()...),
+			}
+			if err := c.saveToCache(reqCtx, rKey, e, expire, model.StaleTime, tag); err != nil {
+				c.C.ErrorTrace(err, "save_to_cache", "cache", from)
+			}
+		}
+
 		// 获取缓存内容
-		resp, err := c.C.Rdb.Get(ctx.Request().Context(), rKey).Result()
-		if err != nil {
-			if err != redis.ErrKeyNotFound {
-				c.C.ErrorTrace(err, "read_cache", "redis", from)
+		entry, has := c.readCache(reqCtx, rKey, from)
+		if has && !entry.stale() {
+			c.writeCache(ctx, entry, "HIT")
+			return
+		}
+		if has {
+			// 已过期 由一个请求刷新
+			if done, ok := c.tryRefresh(reqCtx, rKey); ok {
+				defer done()
+				next()
+				return
 			}
-		} else {
-			// 返回数据
-			result := map[string]interface{}{}
-			err = jsoniter.UnmarshalFromString(resp, &result)
-			if err != nil {
-				c.C.ErrorTrace(err, "json_unmarshal", "json", from)
-			} else {
-				result["status"] = "cache"
-				_, _ = ctx.JSON(result)
+			c.writeCache(ctx, entry, "STALE")
+			return
+		}
+		// 未命中 合并回源
+		leader, wait := c.flight.join(rKey)
+		if !leader {
+			waitCache(reqCtx, wait)
+			if entry, has = c.readCache(reqCtx, rKey, from); has {
+				c.writeCache(ctx, entry, "HIT")
 				return
 			}
+			next()
+			return
+		}
+		defer c.flight.done(rKey)
+		unlock, ok := c.lockCache(reqCtx, rKey)
+		if !ok {
+			// 其他实例正在回源
+			if entry, has = c.pollCache(reqCtx, rKey, from); has {
+				c.writeCache(ctx, entry, "HIT")
+				return
+			}
+		} else {
+			defer unlock()
+		}
+		next()
+	}
+}
+
+// cacheable 回源的响应是否可以缓存
+// 仅缓存200 与开启NotFoundCacheTime时单条的404
+// 响应header Cache-Control 为 no-store 或 private 时不缓存 最后由CacheableFunc决定
+func (c *RestApi) cacheable(ctx iris.Context, model *SingleModel, from string) bool {
+	rec := ctx.Recorder()
+	switch rec.StatusCode() {
+	case iris.StatusOK:
+	case iris.StatusNotFound:
+		if from != "single" || model.NotFoundCacheTime < 1 {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, v := range strings.Split(rec.Header().Get("Cache-Control"), ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "no-store" || v == "private" {
+			return false
 		}
-		ctx.Next()
+	}
+	if model.CacheableFunc != nil {
+		return model.CacheableFunc(ctx, from)
+	}
+	return true
+}
+
+// setCacheStatus 写入缓存状态header HIT MISS STALE
+func (c *RestApi) setCacheStatus(ctx iris.Context, status string) {
+	name := c.C.CacheStatusHeader
+	if name == "-" {
+		return
+	}
+	if len(name) < 1 {
+		name = "X-Cache"
+	}
+	ctx.Header(name, status)
+}
 
+// pollCache 其他实例回源时轮询缓存 超过cacheLockTime仍未获取到时返回false
+func (c *RestApi) pollCache(ctx context.Context, key string, from string) (cacheEntry, bool) {
+	for deadline := time.Now().Add(cacheLockTime); time.Now().Before(deadline); {
+		select {
+		case <-ctx.Done():
+			return cacheEntry{}, false
+		case <-time.After(50 * time.Millisecond):
+		}
+		if entry, has := c.readCache(ctx, key, from); has {
+			return entry, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+// writeCache 原样返回缓存的内容 未变化时返回304
+func (c *RestApi) writeCache(ctx iris.Context, entry cacheEntry, status string) {
+	c.setCacheStatus(ctx, status)
+	if entry.Status == iris.StatusNotFound {
+		c.sendError(ctx, notFoundError(nil))
+		return
+	}
+	etag := entry.Etag
+	if len(etag) < 1 {
+		etag = contentEtag(entry.Body)
+	}
+	var lastModified time.Time
+	if len(entry.LastModified) >= 1 {
+		lastModified, _ = http.ParseTime(entry.LastModified)
+	}
+	if checkNotModified(ctx, etag, lastModified) {
+		return
+	}
+	if len(entry.ContentType) >= 1 {
+		ctx.Header("Content-Type", entry.ContentType)
 	}
+	_, _ = ctx.Write(entry.Body)
 }
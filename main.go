@@ -1,13 +1,13 @@
 package ab
 
 import (
-	"github.com/23233/sv"
+	"fmt"
+	"github.com/didip/tollbooth/v6/limiter"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/pkg/errors"
-	"log"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -15,6 +15,22 @@ import (
 func New(c *Config) *RestApi {
 	a := new(RestApi)
 	a.C = c
+	a.flight = newFlightGroup()
+	a.checkConfig()
+	a.Run()
+	return a
+}
+
+// NewOffline 只解析模型与注册路由 不连接数据库与redis 用于导出typescript与openapi
+// Mdb为空时按MysqlConfig创建engine 不会ping Party为空时使用新的iris实例
+func NewOffline(c *Config) *RestApi {
+	a := new(RestApi)
+	a.C = c
+	a.offline = true
+	a.flight = newFlightGroup()
+	if c.Party == nil {
+		c.Party = iris.New().Party("/")
+	}
 	a.checkConfig()
 	a.Run()
 	return a
@@ -27,6 +43,7 @@ func (c *RestApi) Run() {
 		// 拼接效率高
 		p := strings.Join([]string{"/", item.Prefix, apiName, item.Suffix}, "")
 		api := c.C.Party.Party(p)
+		item.party = api
 
 		// resp解析
 		if item.GetAllResponse != nil {
@@ -74,6 +91,11 @@ func (c *RestApi) Run() {
 		}
 		item.info = info
 
+		// 游标分页需要单一主键 配置错误时启动失败
+		if item.CursorPage && len(info.FieldList.PrimaryKey) < 1 {
+			panic(fmt.Sprintf("[ab] %s cursor page need single primary key", apiName))
+		}
+
 		if item.private {
 			item.privateMapName = item.PrivateColName
 			for _, field := range info.FieldList.Fields {
@@ -96,121 +118,171 @@ func (c *RestApi) Run() {
 				}
 			}
 			item.searchFields = result
+			item.fullText = c.hasFullTextIndex(apiName, result)
 		}
 
+		item.filterOps = parseAllowFilterOps(item.AllowFilterOps, info.FieldList.Fields)
+		item.sortFields = parseAllowSortFields(item.AllowSortFields, info.FieldList.Fields)
+
 		// 判断是否还有其他中间件
 		if len(item.Middlewares) >= 1 {
 			api.Use(item.Middlewares...)
 		}
 
-		// 获取所有方法
-		methods := item.getMethods()
+		c.handleRoutes(api, item, nil)
 
-		if len(methods) >= 1 {
+		// json schema
+		if c.C.SchemaRoute {
+			api.Get("/_schema", modelMiddleware(item), c.SchemaHandler)
+		}
 
-			// 获取全部列表
-			if isContain(methods, "get(all)") {
-				var h context.Handler
-				if item.GetAllFunc == nil {
-					h = c.GetAllFunc
-				} else {
-					h = item.GetAllFunc
-				}
-				r := api.Handle("GET", "/", h)
-				// rate
-				if item.getAllRate() != nil {
-					r.Use(LimitHandler(item.getAllRate(), item.RateErrorFunc))
-				}
-				// cache
-				if item.CacheTime >= 1 || item.GetAllCacheTime >= 1 {
-					r.Use(c.getCacheMiddleware("list"))
-				}
+	}
 
-			}
+	// 关联需要所有模型初始化之后查找
+	c.initRelations()
+	c.registerNested()
 
-			// 获取单条
-			if isContain(methods, "get(single)") {
-				var h context.Handler
-				if item.GetSingleFunc == nil {
-					h = c.GetSingle
-				} else {
-					h = item.GetSingleFunc
-				}
-				r := api.Handle("GET", "/{id:uint64}", h)
-				// rate
-				if item.getSingleRate() != nil {
-					r.Use(LimitHandler(item.getSingleRate(), item.RateErrorFunc))
-				}
-				// cache
-				if item.CacheTime >= 1 || item.GetSingleCacheTime >= 1 {
-					r.Use(c.getCacheMiddleware("single"))
-				}
+	// 模型元数据 可以通过MetaMiddlewares鉴权
+	if c.C.MetaRoute {
+		meta := c.C.Party.Party("/_meta", c.C.MetaMiddlewares...)
+		meta.Get("/", c.MetaHandler)
+		meta.Get("/{name:string}", c.MetaSingleHandler)
+	}
+
+	// openapi文档
+	if len(c.C.OpenApiPath) >= 1 {
+		c.C.Party.Get(c.C.OpenApiPath, c.OpenApiHandler)
+	}
+
+}
+
+// handleRoutes 注册模型的方法路由 rel不为空时为嵌套路由
+// 嵌套路由不注册批量方法 单条获取不使用缓存
+func (c *RestApi) handleRoutes(api iris.Party, item *SingleModel, rel *Relation) {
+	// 获取所有方法
+	methods := item.getMethods()
+
+	if len(methods) >= 1 {
+		// 获取全部列表
+		if isContain(methods, "get(all)") {
+			var h context.Handler
+			if item.GetAllFunc == nil {
+				h = c.GetAllFunc
+			} else {
+				h = item.GetAllFunc
 			}
+			handlers := c.routeHandlers(item, rel, "get(all)", item.getAllRate(), nil)
+			if item.CacheTime >= 1 || item.GetAllCacheTime >= 1 {
+				handlers = append(handlers, c.getCacheMiddleware("list"))
+			}
+			api.Handle("GET", "/", append(handlers, h)...)
+		}
 
-			// 新增
-			if isContain(methods, "post") {
+		// 获取单条
+		if isContain(methods, "get(single)") {
+			var h context.Handler
+			if item.GetSingleFunc == nil {
+				h = c.GetSingle
+			} else {
+				h = item.GetSingleFunc
+			}
+			handlers := c.routeHandlers(item, rel, "get(single)", item.getSingleRate(), nil)
+			if rel == nil && (item.CacheTime >= 1 || item.GetSingleCacheTime >= 1) {
+				handlers = append(handlers, c.getCacheMiddleware("single"))
+			}
+			api.Handle("GET", "/{id:uint64}", append(handlers, h)...)
+		}
 
-				var h context.Handler
-				if item.PostFunc == nil {
-					h = c.AddData
-				} else {
-					h = item.PostFunc
-				}
-				route := api.Handle("POST", "/", h)
+		// 新增
+		if isContain(methods, "post") {
+			var h context.Handler
+			if item.PostFunc == nil {
+				h = c.AddData
+			} else {
+				h = item.PostFunc
+			}
+			handlers := c.routeHandlers(item, rel, "post", item.getAddRate(), item.PostValidator)
+			api.Handle("POST", "/", append(handlers, h)...)
+		}
 
-				// rate
-				if item.getAddRate() != nil {
-					route.Use(LimitHandler(item.getAddRate(), item.RateErrorFunc))
-				}
+		// 批量新增
+		if isContain(methods, "post(bulk)") && rel == nil {
+			var h context.Handler
+			if item.PostBulkFunc == nil {
+				h = c.BulkAddData
+			} else {
+				h = item.PostBulkFunc
+			}
+			handlers := c.routeHandlers(item, rel, "post", item.getAddRate(), nil)
+			api.Handle("POST", "/_bulk", append(handlers, h)...)
+		}
 
-				// 判断是否有自定义验证器
-				if item.PostValidator != nil {
-					route.Use(sv.Run(item.PostValidator))
-				}
+		// 修改
+		if isContain(methods, "put") {
+			var h context.Handler
+			if item.PutFunc == nil {
+				h = c.EditData
+			} else {
+				h = item.PutFunc
 			}
+			handlers := c.routeHandlers(item, rel, "put", item.getEditRate(), item.PutValidator)
+			api.Handle("PUT", "/{id:uint64}", append(handlers, h)...)
+		}
 
-			// 修改
-			if isContain(methods, "put") {
-				var h context.Handler
-				if item.PutFunc == nil {
-					h = c.EditData
-				} else {
-					h = item.PutFunc
-				}
-				route := api.Handle("PUT", "/{id:uint64}", h)
-				// rate
-				if item.getEditRate() != nil {
-					route.Use(LimitHandler(item.getEditRate(), item.RateErrorFunc))
-				}
-				// 判断是否有自定义验证器
-				if item.PutValidator != nil {
-					route.Use(sv.Run(item.PutValidator))
-				}
+		// 部分修改
+		if isContain(methods, "patch") {
+			var h context.Handler
+			if item.PatchFunc == nil {
+				h = c.PatchData
+			} else {
+				h = item.PatchFunc
 			}
+			handlers := c.routeHandlers(item, rel, "put", item.getEditRate(), item.PatchValidator)
+			api.Handle("PATCH", "/{id:uint64}", append(handlers, h)...)
+		}
 
-			// 删除
-			if isContain(methods, "delete") {
-				var h context.Handler
-				if item.DeleteFunc == nil {
-					h = c.DeleteData
-				} else {
-					h = item.DeleteFunc
-				}
-				route := api.Handle("DELETE", "/{id:uint64}", h)
-				// rate
-				if item.getDeleteRate() != nil {
-					route.Use(LimitHandler(item.getDeleteRate(), item.RateErrorFunc))
-				}
-				// 判断是否有自定义验证器
-				if item.DeleteValidator != nil {
-					route.Use(sv.Run(item.DeleteValidator))
-				}
+		// 批量部分修改 需要显式开启
+		if isContain(methods, "patch") && item.AllowBulkEdit && rel == nil {
+			handlers := c.routeHandlers(item, rel, "put", item.getEditRate(), item.PatchValidator)
+			api.Handle("PATCH", "/", append(handlers, c.BulkEditData)...)
+		}
+
+		// 删除
+		if isContain(methods, "delete") {
+			var h context.Handler
+			if item.DeleteFunc == nil {
+				h = c.DeleteData
+			} else {
+				h = item.DeleteFunc
 			}
+			handlers := c.routeHandlers(item, rel, "delete", item.getDeleteRate(), item.DeleteValidator)
+			api.Handle("DELETE", "/{id:uint64}", append(handlers, h)...)
+		}
 
+		// 批量删除 需要显式开启
+		if isContain(methods, "delete") && item.AllowBulkDelete && rel == nil {
+			handlers := c.routeHandlers(item, rel, "delete", item.getDeleteRate(), item.DeleteValidator)
+			api.Handle("DELETE", "/", append(handlers, c.BulkDeleteData)...)
 		}
 
 	}
+}
 
+// routeHandlers 路由的前置处理 设置模型 限流 嵌套路由父数据校验 验证器
+// 需要在party中间件之后执行 才能获取到私密参数 所以不使用route.Use
+func (c *RestApi) routeHandlers(item *SingleModel, rel *Relation, rateName string, rate *limiter.Limiter, valid interface{}) []context.Handler {
+	handlers := make([]context.Handler, 0, 4)
+	handlers = append(handlers, modelMiddleware(item))
+	if h := c.rateMiddleware(item, rateName, rate); h != nil {
+		handlers = append(handlers, h)
+	}
+	if rel != nil {
+		handlers = append(handlers, c.nestedMiddleware(rel))
+	}
+	if valid != nil {
+		handlers = append(handlers, c.validatorMiddleware(valid))
+	}
+	return handlers
 }
 
 // 通过路径获取对应的模型信息
@@ -237,6 +309,9 @@ func (c *RestApi) tableNameReflectFieldsAndTypes(table interface{}) tableFieldsR
 	resp.Deleted = modelInfo.Deleted
 	resp.Created = modelInfo.Created
 	resp.Updated = modelInfo.Updated
+	if len(modelInfo.PrimaryKeys) == 1 {
+		resp.PrimaryKey = modelInfo.PrimaryKeys[0]
+	}
 	return resp
 }
 
@@ -268,7 +343,8 @@ func (c *RestApi) tableNameGetNestedStructMaps(r reflect.Type) []structInfo {
 			result = append(result, d)
 			continue
 		}
-		if field.Type.Kind() == reflect.Struct {
+		// 嵌套结构体展开 json列作为单独的列
+		if field.Type.Kind() == reflect.Struct && !isJsonColumn(field.Tag.Get("xorm")) {
 			values := c.tableNameGetNestedStructMaps(field.Type)
 			result = append(result, values...)
 			continue
@@ -316,98 +392,79 @@ func (c *RestApi) getValue(ctx iris.Context, k string) string {
 	return b
 }
 
-// 对应关系获取
-func (c *RestApi) getCtxValues(routerName string, ctx iris.Context) (reflect.Value, error) {
+// 对应关系获取 json请求从body中解析 其余从form中解析
+// 同时返回请求中存在的列名 字段类型解析错误会以FieldErrors返回
+func (c *RestApi) getCtxValues(routerName string, ctx iris.Context) (reflect.Value, []string, error) {
 	// 先获取到字段信息
 	cb, err := c.tableNameGetModelInfo(routerName)
 	if err != nil {
-		return reflect.Value{}, err
+		return reflect.Value{}, nil, err
 	}
-	t := reflect.TypeOf(cb.Model)
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+	if isJsonRequest(ctx) {
+		var body map[string]jsoniter.RawMessage
+		raw, err := ctx.GetBody()
+		if err != nil {
+			return reflect.Value{}, nil, errors.Wrap(err, "read body error")
+		}
+		if len(raw) >= 1 {
+			err = jsoniter.Unmarshal(raw, &body)
+			if err != nil {
+				return reflect.Value{}, nil, errors.Wrap(err, "json body parse error")
+			}
+		}
+		return c.jsonInstance(cb, body)
 	}
-	newInstance := reflect.New(t)
 
-	fields := cb.info.FieldList
+	newInstance := reflect.New(reflect.Indirect(reflect.ValueOf(cb.Model)).Type())
+	fieldErrors := make(FieldErrors, 0)
+	cols := make([]string, 0)
+	for _, column := range writableColumns(cb.info.FieldList) {
+		fv := columnField(newInstance, column.Name)
+		if !fv.IsValid() || !fv.CanSet() {
+			continue
+		}
+		content := c.getValue(ctx, column.MapName)
+		if len(content) < 1 {
+			continue
+		}
+		if err := setFormValue(fv, column.Types, content); err != nil {
+			fieldErrors[column.MapName] = err.Error()
+			continue
+		}
+		cols = append(cols, column.MapName)
+	}
+	if len(fieldErrors) >= 1 {
+		return reflect.Value{}, nil, fieldErrors
+	}
 
-	for _, column := range fields.Fields {
-		if column.MapName != fields.AutoIncrement {
-			if column.MapName == fields.Updated || column.MapName == fields.Deleted {
-				continue
-			}
-			if len(fields.Created) >= 1 {
-				var equal = false
-				for k := range fields.Created {
-					if column.MapName == k {
-						equal = true
-						break
-					}
-				}
-				if equal {
-					continue
-				}
-			}
-			content := c.getValue(ctx, column.MapName)
-			if len(content) < 1 {
-				continue
-			}
-			switch column.Types {
-			case "string":
-				newInstance.Elem().FieldByName(column.Name).SetString(content)
-				continue
-			case "int", "int8", "int16", "int32", "int64", "time.Duration":
-				d, err := strconv.ParseInt(content, 10, 64)
-				if err != nil {
-					log.Printf("解析出int出错")
-				}
-				newInstance.Elem().FieldByName(column.Name).SetInt(d)
-				continue
-			case "uint", "uint8", "uint16", "uint32", "uint64":
-				d, err := strconv.ParseUint(content, 10, 64)
-				if err != nil {
-					log.Println("解析出uint出错")
-				}
-				newInstance.Elem().FieldByName(column.Name).SetUint(d)
-				continue
-			case "float32", "float64":
-				d, err := strconv.ParseFloat(content, 64)
-				if err != nil {
-					log.Println("解析出float出错")
-				}
-				newInstance.Elem().FieldByName(column.Name).SetFloat(d)
-				continue
-			case "bool":
-				d, err := parseBool(content)
-				if err != nil {
-					log.Println("解析出bool出错")
-				}
-				newInstance.Elem().FieldByName(column.Name).SetBool(d)
-				continue
-			case "time", "time.Time":
-				var tt reflect.Value
-				// 判断是否是字符串
-				if IsNum(content) {
-					// 这里需要转换成时间
-					d, err := strconv.ParseInt(content, 10, 64)
-					if err != nil {
-						return reflect.Value{}, errors.Wrap(err, "time change to int error")
-					}
-					tt = reflect.ValueOf(time.Unix(d, 0))
-				} else {
-					formatTime, err := time.ParseInLocation("2006-01-02 15:04:05", content, time.Local)
-					if err != nil {
-						return reflect.Value{}, errors.Wrap(err, "time parse location error")
-					}
-					tt = reflect.ValueOf(formatTime)
-				}
-				newInstance.Elem().FieldByName(column.Name).Set(tt)
-				continue
-			}
+	return newInstance, cols, nil
+}
+
+// jsonInstance 根据json对象生成模型实例 同时返回存在的列名
+func (c *RestApi) jsonInstance(cb *SingleModel, body map[string]jsoniter.RawMessage) (reflect.Value, []string, error) {
+	newInstance := reflect.New(reflect.Indirect(reflect.ValueOf(cb.Model)).Type())
+	fieldErrors := make(FieldErrors, 0)
+	cols := make([]string, 0)
+	for _, column := range writableColumns(cb.info.FieldList) {
+		fv := columnField(newInstance, column.Name)
+		if !fv.IsValid() || !fv.CanSet() {
+			continue
 		}
+		raw, ok := body[column.MapName]
+		if !ok {
+			continue
+		}
+		if err := setJsonValue(fv, raw); err != nil {
+			fieldErrors[column.MapName] = err.Error()
+			continue
+		}
+		cols = append(cols, column.MapName)
+	}
+	if len(fieldErrors) >= 1 {
+		return reflect.Value{}, nil, fieldErrors
 	}
 
-	return newInstance, nil
+	return newInstance, cols, nil
 }
 
 // 模型反射一个新数据
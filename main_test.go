@@ -1,16 +1,29 @@
 package ab
 
 import (
+	"bytes"
+	_ctx "context"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/didip/tollbooth/v6"
+	"github.com/didip/tollbooth/v6/limiter"
 	"github.com/go-redis/redis/v8"
 	"github.com/iris-contrib/httpexpect/v2"
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/kataras/iris/v12/httptest"
 	_ "github.com/mattn/go-sqlite3"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"xorm.io/xorm"
+	"xorm.io/xorm/contexts"
 )
 
 type testModel struct {
@@ -52,11 +65,14 @@ func TestNew(t *testing.T) {
 	//	Db:       6,
 	//	PoolSize: 100,
 	//}
-	// redis instance
+	// redis instance 使用miniredis 无需本地redis
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     "127.0.0.1:6379",
-		Password: "123456789",
-		DB:       5,
+		Addr: mr.Addr(),
 	})
 
 	checkMc := &Config{
@@ -93,6 +109,63 @@ func TestNew(t *testing.T) {
 	testCache(t, e, fp)
 }
 
+// newTestApp 使用临时sqlite与miniredis生成测试用的app
+func newTestApp(t *testing.T, models ...*SingleModel) (*httpexpect.Expect, *xorm.Engine, string) {
+	return newTestAppWith(t, nil, models...)
+}
+
+// newTestAppWith 与newTestApp一致 setup可以在New之前修改配置
+func newTestAppWith(t *testing.T, setup func(config *Config), models ...*SingleModel) (*httpexpect.Expect, *xorm.Engine, string) {
+	dir, err := ioutil.TempDir("", "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	mdb, err := xorm.NewEngine("sqlite3", filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range models {
+		if err = mdb.Sync2(m.Model); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 错误信息使用了ctx.Tr 需要加载语言文件
+	localeDir := filepath.Join(dir, "en-US")
+	_ = os.Mkdir(localeDir, 0755)
+	locales, err := ioutil.ReadFile("locales.ini")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = ioutil.WriteFile(filepath.Join(localeDir, "locales.ini"), locales, 0644)
+	app := iris.New()
+	if err = app.I18n.Load(filepath.Join(dir, "*", "*.ini"), "en-US"); err != nil {
+		t.Fatal(err)
+	}
+	prefix := "/api/v1"
+	p := app.Party(prefix, func(ctx *context.Context) {
+		ctx.Values().Set("code", 1)
+		ctx.Next()
+	})
+	// 缓存使用miniredis
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mr.Close)
+	config := &Config{
+		Party:         p,
+		MysqlInstance: MysqlInstance{Mdb: mdb},
+		RedisInstance: RedisInstance{Rdb: redis.NewClient(&redis.Options{Addr: mr.Addr()})},
+		Models:        models,
+	}
+	if setup != nil {
+		setup(config)
+	}
+	New(config)
+	return httptest.New(t, app), mdb, prefix
+}
+
 // test crud
 func testCrud(t *testing.T, e *httpexpect.Expect, fp string) {
 	println("run crud test")
@@ -129,17 +202,1275 @@ func testCrud(t *testing.T, e *httpexpect.Expect, fp string) {
 func testCache(t *testing.T, e *httpexpect.Expect, fp string) {
 	println("run cache test")
 
-	fs := fp + "/4"
+	add := e.POST(fp).WithForm(map[string]interface{}{"name": "cache"}).Expect().Status(httptest.StatusOK)
+	fs := fp + "/" + fmt.Sprintf("%v", add.JSON().Object().Value("id").Raw())
 	// get all save to redis
 	e.GET(fp).Expect()
 
 	// get redis cache
 	cacheAll := e.GET(fp).Expect().Status(httptest.StatusOK)
-	cacheAll.JSON().Object().Value("status").Equal("cache")
+	cacheAll.Header("X-Cache").Equal("HIT")
 	println("cache all data list")
 
 	e.GET(fs).Expect()
 	cacheSingle := e.GET(fs).Expect().Status(httptest.StatusOK)
-	cacheSingle.JSON().Object().Value("status").Equal("cache")
+	cacheSingle.Header("X-Cache").Equal("HIT")
 	println("cache single data")
 }
+
+func TestFilterMatch(t *testing.T) {
+	fields := []structInfo{
+		{Name: "Name", Types: "string", MapName: "name"},
+		{Name: "Age", Types: "uint64", MapName: "age"},
+	}
+	allow := parseAllowFilterOps(map[string][]string{"Age": {"gte", "in"}, "name": {"contains"}}, fields)
+
+	filter, or, err := filterMatch(map[string]string{
+		"filter_age__gte":     "18",
+		"filter_name":         "test",
+		"or_name__contains":   "a_b",
+		"filter_unknown__gte": "1",
+	}, fields, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filter) != 2 || len(or) != 1 {
+		t.Fatalf("filter %v or %v", filter, or)
+	}
+	q, args := or[0].sql()
+	if q != "`name` LIKE ? ESCAPE '!'" || args[0] != "%a!_b%" {
+		t.Fatalf("contains sql %s %v", q, args)
+	}
+
+	filter, _, err = filterMatch(map[string]string{"filter_age__in": "1,2,3"}, fields, allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, args = filter[0].sql()
+	if q != "`age` IN (?,?,?)" || args[2] != uint64(3) {
+		t.Fatalf("in sql %s %v", q, args)
+	}
+
+	// eq可以显式使用
+	filter, _, err = filterMatch(map[string]string{"filter_age__eq": "5"}, fields, allow)
+	if err != nil || len(filter) != 1 {
+		t.Fatalf("eq filter %v %v", filter, err)
+	}
+	if q, args = filter[0].sql(); q != "`age` = ?" || args[0] != uint64(5) {
+		t.Fatalf("eq sql %s %v", q, args)
+	}
+
+	// 未允许的操作符
+	if _, _, err = filterMatch(map[string]string{"filter_age__lt": "1"}, fields, allow); err == nil {
+		t.Fatal("lt should not allow")
+	}
+	// 类型错误
+	if _, _, err = filterMatch(map[string]string{"filter_age__gte": "abc"}, fields, allow); err == nil {
+		t.Fatal("age should parse fail")
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	allow := []string{"id", "name", "created"}
+	items, err := parseSort("-created,name,+id", allow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := sortToSql(items); s != "`created` DESC, `name` ASC, `id` ASC" {
+		t.Fatalf("sort sql %s", s)
+	}
+	if _, err = parseSort("name;drop table", allow); err == nil {
+		t.Fatal("unknown field should fail")
+	}
+	if _, err = parseSort("name,-name", allow); err == nil {
+		t.Fatal("duplicate field should fail")
+	}
+}
+
+func TestSearchSql(t *testing.T) {
+	m := new(SingleModel)
+	mode, s, err := m.getSearchMode("", "__赵日天")
+	if err != nil || mode != SearchSuffix || s != "赵日天" {
+		t.Fatalf("auto mode %s %s %v", mode, s, err)
+	}
+	q, args := searchSql(SearchContains, []string{"name", "desc"}, "50%'")
+	if q != "(`name` LIKE ? ESCAPE '!' OR `desc` LIKE ? ESCAPE '!')" || args[0] != "%50!%'%" {
+		t.Fatalf("contains sql %s %v", q, args)
+	}
+	if _, _, err = m.getSearchMode(SearchFullText, "x"); err == nil {
+		t.Fatal("fulltext should not enable")
+	}
+}
+
+func TestCursorPage(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testModel), CursorPage: true})
+	// 没有数据时返回空数组
+	empty := e.GET(prefix + "/" + mdb.TableName(new(testModel))).Expect().Status(httptest.StatusOK).JSON().Object()
+	empty.Value("data").Array().Empty()
+	empty.Value("next_cursor").Equal("")
+	empty.Value("all").Equal(0)
+	for i := 1; i <= 5; i++ {
+		_, _ = mdb.InsertOne(&testModel{Name: fmt.Sprintf("n%d", i), Age: uint64(i % 2)})
+	}
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+
+	first := e.GET(fp).WithQuery("page_size", 2).WithQuery("sort", "-age").Expect().Status(httptest.StatusOK).JSON().Object()
+	first.Value("all").Equal(5)
+	first.Value("prev_cursor").Equal("")
+	first.Value("data").Array().Length().Equal(2)
+	next := first.Value("next_cursor").String().Raw()
+
+	second := e.GET(fp).WithQuery("page_size", 2).WithQuery("sort", "-age").WithQuery("cursor", next).WithQuery("count", 0).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	second.NotContainsKey("all")
+	// -age 后主键同为倒序 n5 n3 | n1 n4 | n2
+	second.Value("data").Array().Element(0).Object().Value("name").Equal("n1")
+	prev := second.Value("prev_cursor").String().Raw()
+
+	back := e.GET(fp).WithQuery("page_size", 2).WithQuery("sort", "-age").WithQuery("cursor", prev).Expect().Status(httptest.StatusOK).JSON().Object()
+	back.Value("data").Array().Element(0).Object().Value("name").Equal("n5")
+	back.Value("prev_cursor").Equal("")
+
+	// 换了排序的游标不能使用
+	e.GET(fp).WithQuery("cursor", next).Expect().Status(httptest.StatusBadRequest)
+
+	// 没有单一主键时启动失败
+	defer func() {
+		if recover() == nil {
+			t.Fatal("cursor page without primary key should panic")
+		}
+	}()
+	newTestApp(t, &SingleModel{Model: new(testNoPkModel), CursorPage: true})
+}
+
+type testNullSortModel struct {
+	Id   uint64 `xorm:"autoincr pk unique" json:"id"`
+	Name string `xorm:"varchar(10)" json:"name"`
+	Age  *int   `json:"age"`
+}
+
+func TestCursorPageNull(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testNullSortModel), CursorPage: true})
+	one, two := 1, 2
+	for _, row := range []*testNullSortModel{{Name: "a", Age: &two}, {Name: "b"}, {Name: "c", Age: &one}, {Name: "d"}, {Name: "e", Age: &two}} {
+		_, _ = mdb.InsertOne(row)
+	}
+	fp := prefix + "/" + mdb.TableName(new(testNullSortModel))
+	// NULL视为最小值 逐页向后再逐页向前
+	for sort, want := range map[string]string{"age": "bdcae", "-age": "eacdb"} {
+		var names, cursor string
+		var pages []string
+		for i := 0; i < 10; i++ {
+			req := e.GET(fp).WithQuery("page_size", 1).WithQuery("sort", sort)
+			if len(cursor) >= 1 {
+				req = req.WithQuery("cursor", cursor)
+			}
+			page := req.Expect().Status(httptest.StatusOK).JSON().Object()
+			page.Value("data").Array().Element(0).Object().NotContainsKey(cursorNullKey(0))
+			names += page.Value("data").Array().Element(0).Object().Value("name").String().Raw()
+			pages = append(pages, page.Value("prev_cursor").String().Raw())
+			if cursor = page.Value("next_cursor").String().Raw(); len(cursor) < 1 {
+				break
+			}
+		}
+		if names != want {
+			t.Fatalf("sort %s forward %s want %s", sort, names, want)
+		}
+		var back string
+		for cursor = pages[len(pages)-1]; len(cursor) >= 1; {
+			page := e.GET(fp).WithQuery("page_size", 1).WithQuery("sort", sort).WithQuery("cursor", cursor).
+				Expect().Status(httptest.StatusOK).JSON().Object()
+			back = page.Value("data").Array().Element(0).Object().Value("name").String().Raw() + back
+			cursor = page.Value("prev_cursor").String().Raw()
+		}
+		if back != want[:len(want)-1] {
+			t.Fatalf("sort %s backward %s want %s", sort, back, want[:len(want)-1])
+		}
+	}
+}
+
+type testNoPkModel struct {
+	Name string `xorm:"varchar(10)" json:"name"`
+}
+
+type testJsonMeta struct {
+	Level int    `json:"level"`
+	Note  string `json:"note"`
+}
+
+type testJsonModel struct {
+	Id    uint64       `xorm:"autoincr pk unique" json:"id"`
+	Name  string       `xorm:"varchar(10)" json:"name"`
+	Age   int          `json:"age"`
+	Tags  []string     `xorm:"json" json:"tags"`
+	Meta  testJsonMeta `xorm:"json" json:"meta"`
+	Birth time.Time    `json:"birth"`
+}
+
+type testJsonValid struct {
+	Name string `json:"name" comment:"名称" validate:"required"`
+}
+
+func TestJsonBody(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testJsonModel), PostValidator: new(testJsonValid)})
+	fp := prefix + "/" + mdb.TableName(new(testJsonModel))
+
+	body := map[string]interface{}{
+		"name":  "json",
+		"age":   "18",
+		"tags":  []string{"a", "b"},
+		"meta":  map[string]interface{}{"level": 2, "note": "n"},
+		"birth": "2020-01-02 03:04:05",
+	}
+	add := e.POST(fp).WithJSON(body).Expect().Status(httptest.StatusOK).JSON().Object()
+	add.Value("age").Equal(18)
+	add.Value("tags").Array().Elements("a", "b")
+	add.Value("meta").Object().Value("level").Equal(2)
+	id := add.Value("id").Raw()
+
+	var row testJsonModel
+	_, _ = mdb.ID(uint64(id.(float64))).Get(&row)
+	if row.Meta.Note != "n" || len(row.Tags) != 2 || row.Birth.Year() != 2020 {
+		t.Fatalf("row %+v", row)
+	}
+
+	// 类型错误按字段返回
+	bad := e.POST(fp).WithJSON(map[string]interface{}{"name": "x", "age": "abc", "tags": 1}).Expect().Status(httptest.StatusUnprocessableEntity).JSON().Object()
+	bad.Value("fields").Object().ContainsKey("age").ContainsKey("tags")
+
+	// null 写入零值
+	e.PUT(fmt.Sprintf("%s/%v", fp, id)).WithJSON(map[string]interface{}{"name": "edit", "tags": nil}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("tags").Null()
+
+	// form 仍然可用
+	e.POST(fp).WithForm(map[string]interface{}{"name": "form"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("name").Equal("form")
+}
+
+type testContact struct {
+	Phone string `xorm:"varchar(20)" json:"phone"`
+	City  string `xorm:"varchar(20)" json:"city"`
+}
+
+type testNestedModel struct {
+	Id      uint64      `xorm:"autoincr pk unique" json:"id"`
+	Name    string      `xorm:"varchar(10)" json:"name"`
+	Contact testContact `xorm:"extends" json:"contact"`
+}
+
+func TestNestedStructBody(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testNestedModel)})
+	fp := prefix + "/" + mdb.TableName(new(testNestedModel))
+
+	// 具名嵌套结构体展开的列 json与form均可写入
+	e.POST(fp).WithJSON(map[string]interface{}{"name": "a", "phone": "123", "city": "x"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("contact").Object().Value("phone").Equal("123")
+	e.POST(fp).WithForm(map[string]interface{}{"name": "b", "city": "y"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("contact").Object().Value("city").Equal("y")
+	e.PATCH(fp + "/1").WithJSON(map[string]interface{}{"city": "z"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("contact").Object().Value("city").Equal("z")
+	var row testNestedModel
+	_, _ = mdb.ID(1).Get(&row)
+	if row.Contact.Phone != "123" || row.Contact.City != "z" {
+		t.Fatalf("row %+v", row)
+	}
+	e.POST(fp).WithJSON(map[string]interface{}{"name": "c", "phone": []int{1}}).Expect().Status(httptest.StatusUnprocessableEntity).
+		JSON().Object().Value("fields").Object().ContainsKey("phone")
+}
+
+func TestPatchData(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testModel)})
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	row := &testModel{Name: "patch", Age: 20, Desc: "desc"}
+	_, _ = mdb.InsertOne(row)
+	fs := fmt.Sprintf("%s/%d", fp, row.Id)
+
+	patch := e.PATCH(fs).WithJSON(map[string]interface{}{"name": "p2"}).Expect().Status(httptest.StatusOK).JSON().Object()
+	patch.Value("name").Equal("p2")
+	patch.Value("age").Equal(20)
+	patch.Value("desc").Equal("desc")
+
+	// 允许更新为零值
+	e.PATCH(fs).WithJSON(map[string]interface{}{"age": 0}).Expect().Status(httptest.StatusOK).JSON().Object().Value("age").Equal(0)
+
+	e.PATCH(fs).WithJSON(map[string]interface{}{}).Expect().Status(httptest.StatusBadRequest)
+
+	// put 为全量更新
+	e.PUT(fs).WithJSON(map[string]interface{}{"name": "p3"}).Expect().Status(httptest.StatusOK)
+	var r testModel
+	_, _ = mdb.ID(row.Id).Get(&r)
+	if r.Desc != "" || r.Name != "p3" {
+		t.Fatalf("put row %+v", r)
+	}
+
+	// 禁用put或覆盖put时 patch不会默认开启
+	e, mdb, _ = newTestApp(t, &SingleModel{Model: new(testModel), DisableMethods: []string{"put", "post"}})
+	_, _ = mdb.InsertOne(&testModel{Name: "patch"})
+	e.GET(fp + "/1").Expect().Status(httptest.StatusOK)
+	e.PATCH(fp + "/1").WithJSON(map[string]interface{}{"name": "p4"}).Expect().Status(httptest.StatusNotFound)
+	e, mdb, _ = newTestApp(t, &SingleModel{Model: new(testModel), PutFunc: func(ctx iris.Context) {
+		ctx.StopWithStatus(iris.StatusForbidden)
+	}})
+	_, _ = mdb.InsertOne(&testModel{Name: "patch"})
+	e.PATCH(fp + "/1").WithJSON(map[string]interface{}{"name": "p4"}).Expect().Status(httptest.StatusNotFound)
+	e.GET(fp + "/1").Expect().Status(httptest.StatusOK).JSON().Object().Value("name").Equal("patch")
+	// AllowMethods中显式开启
+	e, mdb, _ = newTestApp(t, &SingleModel{Model: new(testModel), AllowMethods: []string{"get(single)", "patch"}})
+	_, _ = mdb.InsertOne(&testModel{Name: "patch"})
+	e.PATCH(fp + "/1").WithJSON(map[string]interface{}{"name": "p4"}).Expect().Status(httptest.StatusOK)
+}
+
+type testVersionModel struct {
+	Id      uint64 `xorm:"autoincr pk unique" json:"id"`
+	Name    string `xorm:"varchar(10)" json:"name"`
+	Version int    `xorm:"version" json:"version"`
+}
+
+func TestIfMatch(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testVersionModel), RequireIfMatch: true})
+	fp := prefix + "/" + mdb.TableName(new(testVersionModel))
+	row := &testVersionModel{Name: "v"}
+	_, _ = mdb.InsertOne(row)
+	fs := fmt.Sprintf("%s/%d", fp, row.Id)
+
+	etag := e.GET(fs).Expect().Status(httptest.StatusOK).Header("ETag").Raw()
+	if etag != `"v1"` {
+		t.Fatalf("etag %s", etag)
+	}
+	e.PATCH(fs).WithJSON(map[string]interface{}{"name": "a"}).Expect().Status(httptest.StatusPreconditionRequired)
+	e.PATCH(fs).WithHeader("If-Match", `"v9"`).WithJSON(map[string]interface{}{"name": "a"}).Expect().Status(httptest.StatusPreconditionFailed)
+
+	r := e.PATCH(fs).WithHeader("If-Match", etag).WithJSON(map[string]interface{}{"name": "a"}).Expect().Status(httptest.StatusOK)
+	r.Header("ETag").Equal(`"v2"`)
+	r.JSON().Object().Value("version").Equal(2)
+
+	// 旧版本不能再次修改和删除
+	e.PUT(fs).WithHeader("If-Match", etag).WithJSON(map[string]interface{}{"name": "b"}).Expect().Status(httptest.StatusPreconditionFailed)
+	e.DELETE(fs).WithHeader("If-Match", etag).Expect().Status(httptest.StatusPreconditionFailed)
+	e.DELETE(fs).WithHeader("If-Match", `"v2"`).Expect().Status(httptest.StatusOK)
+}
+
+type testUpdatedModel struct {
+	Id      uint64    `xorm:"autoincr pk unique" json:"id"`
+	Name    string    `xorm:"varchar(10)" json:"name"`
+	Updated time.Time `xorm:"updated" json:"updated"`
+}
+
+func TestConditionalGet(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testUpdatedModel)})
+	fp := prefix + "/" + mdb.TableName(new(testUpdatedModel))
+	row := &testUpdatedModel{Name: "c"}
+	_, _ = mdb.InsertOne(row)
+	fs := fmt.Sprintf("%s/%d", fp, row.Id)
+
+	single := e.GET(fs).Expect().Status(httptest.StatusOK)
+	etag := single.Header("ETag").NotEmpty().Raw()
+	lastModified := single.Header("Last-Modified").NotEmpty().Raw()
+	e.GET(fs).WithHeader("If-None-Match", etag).Expect().Status(httptest.StatusNotModified).Body().Empty()
+	e.GET(fs).WithHeader("If-Modified-Since", lastModified).Expect().Status(httptest.StatusNotModified)
+
+	list := e.GET(fp).Expect().Status(httptest.StatusOK)
+	listEtag := list.Header("ETag").NotEmpty().Raw()
+	e.GET(fp).WithHeader("If-None-Match", listEtag).Expect().Status(httptest.StatusNotModified)
+
+	// 数据变化后etag不同
+	e.PATCH(fs).WithJSON(map[string]interface{}{"name": "d"}).Expect().Status(httptest.StatusOK)
+	e.GET(fs).WithHeader("If-None-Match", etag).Expect().Status(httptest.StatusOK)
+	e.GET(fp).WithHeader("If-None-Match", listEtag).Expect().Status(httptest.StatusOK)
+}
+
+type testUniqueModel struct {
+	Id   uint64 `xorm:"autoincr pk unique" json:"id"`
+	Name string `xorm:"varchar(10) unique" json:"name"`
+}
+
+func TestErrorResponse(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testUniqueModel), PostValidator: new(testJsonValid)})
+	fp := prefix + "/" + mdb.TableName(new(testUniqueModel))
+
+	e.GET(fp + "/99").Expect().Status(httptest.StatusNotFound).JSON().Object().Value("code").Equal(CodeNotFoundDataFail)
+
+	// 验证失败按字段返回
+	valid := e.POST(fp).WithJSON(map[string]interface{}{}).Expect().Status(httptest.StatusUnprocessableEntity).JSON().Object()
+	valid.Value("code").Equal(CodeValidateFail)
+	valid.Value("fields").Object().ContainsKey("name")
+
+	e.POST(fp).WithJSON(map[string]interface{}{"name": "u"}).Expect().Status(httptest.StatusOK)
+	e.POST(fp).WithJSON(map[string]interface{}{"name": "u"}).Expect().Status(httptest.StatusConflict).
+		JSON().Object().Value("code").Equal(CodeUniqueFail)
+
+	// rfc7807
+	problem := e.GET(fp+"/99").WithHeader("Accept", "application/problem+json").Expect().Status(httptest.StatusNotFound)
+	problem.ContentType("application/problem+json")
+	obj := problem.JSON(httpexpect.ContentOpts{MediaType: "application/problem+json"}).Object()
+	obj.Value("status").Equal(404)
+	obj.Value("code").Equal(CodeNotFoundDataFail)
+}
+
+func TestBulkAdd(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testUniqueModel), PostValidator: new(testJsonValid), MaxBulkSize: 3, MaxBulkBodySize: 128},
+		&SingleModel{Model: new(testJsonModel), BulkBestEffort: true, BulkTxSize: 2},
+	)
+	fp := prefix + "/" + mdb.TableName(new(testUniqueModel)) + "/_bulk"
+
+	ok := e.POST(fp).WithJSON([]map[string]interface{}{{"name": "a"}, {"name": "b"}}).Expect().Status(httptest.StatusOK).JSON().Object()
+	ok.Value("success").Equal(2)
+	ok.Value("results").Array().Element(1).Object().Value("data").Object().Value("name").Equal("b")
+
+	// 默认全部成功或全部失败
+	bad := e.POST(fp).WithJSON([]map[string]interface{}{{"name": "c"}, {}}).Expect().Status(httptest.StatusUnprocessableEntity).JSON().Object()
+	bad.Value("code").Equal(CodeBulkFail)
+	bad.Value("results").Array().Element(0).Object().Value("index").Equal(1)
+	e.POST(fp).WithJSON([]map[string]interface{}{{"name": "c"}, {"name": "a"}}).Expect().Status(httptest.StatusConflict)
+	if n, _ := mdb.Count(new(testUniqueModel)); n != 2 {
+		t.Fatalf("count %d", n)
+	}
+	e.POST(fp).WithJSON([]map[string]interface{}{{"name": "1"}, {"name": "2"}, {"name": "3"}, {"name": "4"}}).Expect().Status(httptest.StatusRequestEntityTooLarge)
+	e.POST(fp).WithJSON([]map[string]interface{}{{"name": strings.Repeat("x", 200)}}).Expect().Status(httptest.StatusRequestEntityTooLarge).
+		JSON().Object().Value("code").Equal(CodeBulkSizeFail)
+
+	// ndjson 跳过失败的条目
+	fj := prefix + "/" + mdb.TableName(new(testJsonModel)) + "/_bulk"
+	best := e.POST(fj).WithHeader("Content-Type", "application/x-ndjson").
+		WithBytes([]byte("{\"name\":\"n1\"}\n{\"name\":\"n2\",\"age\":\"x\"}\n\n{\"name\":\"n3\"}\n")).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	best.Value("success").Equal(2)
+	best.Value("fail").Equal(1)
+	best.Value("results").Array().Element(1).Object().Value("error").Object().Value("fields").Object().ContainsKey("age")
+	if n, _ := mdb.Count(new(testJsonModel)); n != 2 {
+		t.Fatalf("count %d", n)
+	}
+
+	// 禁用post或覆盖PostFunc时 批量新增不会默认开启
+	e, mdb, _ = newTestApp(t,
+		&SingleModel{Model: new(testModel), DisableMethods: []string{"post"}},
+		&SingleModel{Model: new(testUniqueModel), PostFunc: func(ctx iris.Context) {
+			ctx.StopWithStatus(iris.StatusForbidden)
+		}},
+	)
+	e.POST(prefix + "/" + mdb.TableName(new(testModel)) + "/_bulk").WithJSON([]map[string]interface{}{{"name": "a"}}).
+		Expect().Status(httptest.StatusNotFound)
+	e.POST(fp).WithJSON([]map[string]interface{}{{"name": "a"}}).Expect().Status(httptest.StatusNotFound)
+	n1, _ := mdb.Count(new(testModel))
+	n2, _ := mdb.Count(new(testUniqueModel))
+	if n1+n2 != 0 {
+		t.Fatalf("count %d %d", n1, n2)
+	}
+}
+
+// testInsertHook 统计执行的insert语句数量
+type testInsertHook struct {
+	count int32
+}
+
+func (h *testInsertHook) BeforeProcess(c *contexts.ContextHook) (_ctx.Context, error) {
+	if strings.HasPrefix(c.SQL, "INSERT") {
+		atomic.AddInt32(&h.count, 1)
+	}
+	return c.Ctx, nil
+}
+
+func (h *testInsertHook) AfterProcess(c *contexts.ContextHook) error {
+	return nil
+}
+
+// checkBulkIds 成功条目返回的自增主键与数据库中一致
+func checkBulkIds(t *testing.T, mdb *xorm.Engine, results *httpexpect.Array) {
+	for _, v := range results.Iter() {
+		if _, has := v.Object().Raw()["data"]; !has {
+			continue
+		}
+		data := v.Object().Value("data").Object()
+		var row testUniqueModel
+		if has, _ := mdb.Where("name = ?", data.Value("name").String().Raw()).Get(&row); !has {
+			t.Fatalf("row not found %v", data.Raw())
+		}
+		data.Value("id").Equal(row.Id)
+	}
+}
+
+func TestBulkAddBatch(t *testing.T) {
+	hook := new(testInsertHook)
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testUniqueModel), BulkTxSize: 2})
+	mdb.AddHook(hook)
+	fp := prefix + "/" + mdb.TableName(new(testUniqueModel)) + "/_bulk"
+	_, _ = mdb.InsertOne(&testUniqueModel{Name: "z"})
+
+	// 每BulkTxSize条一条insert 最后剩余的一条单独写入
+	atomic.StoreInt32(&hook.count, 0)
+	ok := e.POST(fp).WithJSON([]map[string]interface{}{{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}, {"name": "e"}}).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	ok.Value("success").Equal(5)
+	if n := atomic.LoadInt32(&hook.count); n != 3 {
+		t.Fatalf("insert count %d", n)
+	}
+	checkBulkIds(t, mdb, ok.Value("results").Array())
+
+	// 批量写入失败时逐条重试找出失败的条目
+	bad := e.POST(fp).WithJSON([]map[string]interface{}{{"name": "f"}, {"name": "g"}, {"name": "a"}, {"name": "h"}}).
+		Expect().Status(httptest.StatusConflict).JSON().Object()
+	bad.Value("results").Array().Element(0).Object().Value("index").Equal(2)
+	if n, _ := mdb.Count(new(testUniqueModel)); n != 6 {
+		t.Fatalf("count %d", n)
+	}
+	ok = e.POST(fp).WithJSON([]map[string]interface{}{{"name": "f"}, {"name": "g"}}).Expect().Status(httptest.StatusOK).JSON().Object()
+	checkBulkIds(t, mdb, ok.Value("results").Array())
+
+	e, mdb, _ = newTestApp(t, &SingleModel{Model: new(testUniqueModel), BulkBestEffort: true, BulkTxSize: 2})
+	_, _ = mdb.InsertOne(&testUniqueModel{Name: "a"})
+	best := e.POST(fp).WithJSON([]map[string]interface{}{{"name": "p"}, {"name": "a"}, {"name": "q"}, {"name": "r"}}).
+		Expect().Status(httptest.StatusOK).JSON().Object()
+	best.Value("success").Equal(3)
+	best.Value("results").Array().Element(1).Object().Value("error").Object().Value("code").Equal(CodeUniqueFail)
+	checkBulkIds(t, mdb, best.Value("results").Array())
+}
+
+// testEndlessReader 不断返回同一段内容的请求体
+type testEndlessReader string
+
+func (r testEndlessReader) Read(p []byte) (int, error) {
+	return copy(p, r), nil
+}
+
+func TestParseBulkBody(t *testing.T) {
+	items, err := parseBulkBody(strings.NewReader("[{\"name\":\"a\"}, {\"name\":\"b\"}]"), false, 2)
+	if err != nil || len(items) != 2 || string(items[1]) != `{"name":"b"}` {
+		t.Fatalf("items %v %v", items, err)
+	}
+	if _, err := parseBulkBody(strings.NewReader(`{"name":"a"}`), false, 2); err == nil {
+		t.Fatal("object body should fail")
+	}
+	// 超过条数时不再继续读取
+	_, err = parseBulkBody(io.MultiReader(strings.NewReader("["), testEndlessReader(`{"name":"x"},`)), false, 3)
+	if e, ok := err.(*ApiError); !ok || e.Code != CodeBulkSizeFail {
+		t.Fatalf("json array size err %v", err)
+	}
+	_, err = parseBulkBody(testEndlessReader("{\"name\":\"x\"}\n"), true, 3)
+	if e, ok := err.(*ApiError); !ok || e.Code != CodeBulkSizeFail {
+		t.Fatalf("ndjson size err %v", err)
+	}
+	// 超过请求体大小时不再继续读取
+	_, err = parseBulkBody(&bulkBodyReader{r: testEndlessReader("\n"), left: 1024}, true, 1000)
+	if e, ok := err.(*ApiError); !ok || e.Code != CodeBulkSizeFail {
+		t.Fatalf("body size err %v", err)
+	}
+}
+
+func TestBulkEditDelete(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testModel), PrivateContextKey: "code", PrivateColName: "code", AllowFilterOps: map[string][]string{"age": {"lte", "gte"}},
+			AllowBulkEdit: true, AllowBulkDelete: true, MaxBulkAffected: 2},
+		&SingleModel{Model: new(testVersionModel), AllowBulkEdit: true},
+	)
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	rows := []*testModel{{Name: "a", Age: 1, Code: 1}, {Name: "b", Age: 2, Code: 1}, {Name: "c", Age: 3, Code: 1}, {Name: "d", Age: 1, Code: 2}}
+	for _, row := range rows {
+		_, _ = mdb.InsertOne(row)
+	}
+
+	// 没有条件不允许执行
+	e.PATCH(fp).WithJSON(map[string]interface{}{"desc": "x"}).Expect().Status(httptest.StatusBadRequest)
+	e.PATCH(fp).WithQuery("filter_age__lte", 2).WithJSON(map[string]interface{}{"desc": "x"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("affected").Equal(2)
+	// or条件同样限定在私密参数范围内
+	e.PATCH(fp).WithQuery("filter_age", 99).WithQuery("or_age", 1).WithJSON(map[string]interface{}{"desc": "y"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("affected").Equal(1)
+	var other testModel
+	_, _ = mdb.ID(rows[3].Id).Get(&other)
+	if other.Desc != "" {
+		t.Fatalf("other private row updated %+v", other)
+	}
+
+	// 超过最大条数不执行
+	e.DELETE(fp).WithQuery("filter_age__gte", 0).Expect().Status(httptest.StatusBadRequest).JSON().Object().Value("code").Equal(CodeBulkLimitFail)
+	e.DELETE(fp).WithQuery("ids", fmt.Sprintf("%d,%d,%d", rows[0].Id, rows[1].Id, rows[3].Id)).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("affected").Equal(2)
+	if n, _ := mdb.Count(new(testModel)); n != 2 {
+		t.Fatalf("count %d", n)
+	}
+
+	// 版本号自增
+	fv := prefix + "/" + mdb.TableName(new(testVersionModel))
+	v := &testVersionModel{Name: "v"}
+	_, _ = mdb.InsertOne(v)
+	e.PATCH(fv).WithQuery("ids", v.Id).WithJSON(map[string]interface{}{"name": "w"}).Expect().Status(httptest.StatusOK)
+	e.GET(fmt.Sprintf("%s/%d", fv, v.Id)).Expect().Status(httptest.StatusOK).JSON().Object().Value("version").Equal(2)
+	e.DELETE(fv).WithQuery("ids", v.Id).Expect().Status(httptest.StatusNotFound)
+}
+
+func TestListCacheInvalidate(t *testing.T) {
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testModel), GetAllCacheTime: time.Minute})
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	_, _ = mdb.InsertOne(&testModel{Name: "a", Code: 1})
+
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("all").Equal(1)
+	e.GET(fp).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+
+	// 写入后列表缓存被清除
+	add := e.POST(fp).WithForm(map[string]interface{}{"name": "b"}).Expect().Status(httptest.StatusOK).JSON().Object()
+	list := e.GET(fp).Expect().Status(httptest.StatusOK)
+	list.Header("X-Cache").Equal("MISS")
+	list.JSON().Object().Value("all").Equal(2)
+
+	e.GET(fp).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+	e.DELETE(fmt.Sprintf("%s/%v", fp, add.Value("id").Raw())).Expect().Status(httptest.StatusOK)
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("all").Equal(1)
+}
+
+func TestSingleCacheInvalidate(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testModel), GetSingleCacheTime: time.Minute, PrivateContextKey: "code", PrivateColName: "code", AllowBulkEdit: true},
+	)
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	row := &testModel{Name: "a", Code: 1}
+	_, _ = mdb.InsertOne(row)
+	fs := fmt.Sprintf("%s/%d", fp, row.Id)
+
+	// key与请求地址无关
+	e.GET(fs).WithQuery("x", 1).Expect().Status(httptest.StatusOK)
+	e.GET(fs).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+
+	e.PUT(fs).WithForm(map[string]interface{}{"name": "b"}).Expect().Status(httptest.StatusOK)
+	single := e.GET(fs).Expect().Status(httptest.StatusOK)
+	single.Header("X-Cache").Equal("MISS")
+	single.JSON().Object().Value("name").Equal("b")
+
+	// 批量修改按标签清除
+	e.GET(fs).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+	e.PATCH(fp).WithQuery("ids", row.Id).WithJSON(map[string]interface{}{"name": "c"}).Expect().Status(httptest.StatusOK)
+	e.GET(fs).Expect().Status(httptest.StatusOK).JSON().Object().Value("name").Equal("c")
+
+	e.DELETE(fs).Expect().Status(httptest.StatusOK)
+	e.GET(fs).Expect().Status(httptest.StatusNotFound)
+
+	m := &SingleModel{PrivateColName: "code"}
+	if m.singleKey(1, 1) == m.singleKey(1, 2) || m.singleKey(1, 1) == m.singleKey(2, 1) {
+		t.Fatal("single key should contain id and private value")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	ctx := _ctx.Background()
+	c := NewMemoryCache(2)
+	_ = c.Set(ctx, "a", []byte("1"), time.Minute, "t1")
+	_ = c.Set(ctx, "b", []byte("2"), time.Minute, "t2")
+	_, _, _ = c.Get(ctx, "a")
+	// 超过最大条数淘汰最久未使用的b
+	_ = c.Set(ctx, "c", []byte("3"), time.Minute, "t1")
+	if _, ok, _ := c.Get(ctx, "b"); ok || c.Len() != 2 {
+		t.Fatal("lru evict fail")
+	}
+	_ = c.DeleteByTag(ctx, "t1")
+	if c.Len() != 0 {
+		t.Fatal("delete by tag fail")
+	}
+	_ = c.Set(ctx, "d", []byte("4"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := c.Get(ctx, "d"); ok {
+		t.Fatal("ttl fail")
+	}
+
+	// 二级缓存 从L2取回的数据同样会被标签清除
+	l1, l2 := NewMemoryCache(0), NewMemoryCache(0)
+	tc := NewTieredCache(l1, l2, time.Minute)
+	_ = l2.Set(ctx, "k", []byte("v"), time.Minute, "tag")
+	if b, ok, _ := tc.Get(ctx, "k"); !ok || string(b) != "v" || l1.Len() != 1 {
+		t.Fatal("tiered fill fail")
+	}
+	_ = tc.DeleteByTag(ctx, "tag")
+	if _, ok, _ := tc.Get(ctx, "k"); ok {
+		t.Fatal("tiered delete by tag fail")
+	}
+}
+
+func TestMemoryCacheConfig(t *testing.T) {
+	// 使用内存缓存时不需要redis
+	e, mdb, prefix := newTestAppWith(t, func(config *Config) {
+		config.RedisInstance = RedisInstance{}
+		config.Cache = NewMemoryCache(0)
+	}, &SingleModel{Model: new(testModel), CacheTime: time.Minute})
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	e.GET(fp).Expect().Status(httptest.StatusOK)
+	e.GET(fp).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+	e.POST(fp).WithForm(map[string]interface{}{"name": "m"}).Expect().Status(httptest.StatusOK)
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("all").Equal(1)
+}
+
+func TestCacheStampede(t *testing.T) {
+	var calls int32
+	var slow int32
+	model := &SingleModel{Model: new(testModel), CacheTime: 100 * time.Millisecond, StaleTime: time.Minute,
+		GetAllResponseFunc: func(ctx iris.Context, result iris.Map, dataList []map[string]string) iris.Map {
+			atomic.AddInt32(&calls, 1)
+			if atomic.LoadInt32(&slow) == 1 {
+				time.Sleep(200 * time.Millisecond)
+			}
+			return result
+		}}
+	e, mdb, prefix := newTestAppWith(t, func(config *Config) {
+		config.Cache = NewMemoryCache(0)
+	}, model)
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+
+	// 同时未命中只回源一次
+	atomic.StoreInt32(&slow, 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e.GET(fp).Expect().Status(httptest.StatusOK)
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("calls %d", n)
+	}
+
+	// 过期后一个请求刷新 其余返回旧数据
+	_, _ = mdb.InsertOne(&testModel{Name: "s"})
+	time.Sleep(150 * time.Millisecond)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("all").Equal(1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	stale := e.GET(fp).Expect().Status(httptest.StatusOK)
+	stale.Header("X-Cache").Equal("STALE")
+	stale.JSON().Object().Value("all").Equal(0)
+	wg.Wait()
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("all").Equal(1)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("calls %d", n)
+	}
+}
+
+func TestRedisCacheLock(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := _ctx.Background()
+	unlock, ok, err := c.Lock(ctx, "k", time.Second)
+	if err != nil || !ok {
+		t.Fatal("lock fail", err)
+	}
+	if _, ok, _ = c.Lock(ctx, "k", time.Second); ok {
+		t.Fatal("lock should be held")
+	}
+	unlock()
+	if _, ok, _ = c.Lock(ctx, "k", time.Second); !ok {
+		t.Fatal("lock should be released")
+	}
+}
+
+func TestRedisCacheTag(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	c := NewRedisCache(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	ctx := _ctx.Background()
+	_ = c.Set(ctx, "a", []byte("1"), time.Minute, "t1")
+	_ = c.Set(ctx, "b", []byte("2"), time.Minute, "t1", "t2")
+	_ = c.Set(ctx, "c", []byte("3"), time.Minute, "t2")
+	if err = c.DeleteByTag(ctx, "t1"); err != nil {
+		t.Fatal(err)
+	}
+	for key, has := range map[string]bool{"a": false, "b": false, "c": true, "t1": false, "t2": true} {
+		if mr.Exists(key) != has {
+			t.Fatalf("key %s exists should be %v", key, has)
+		}
+	}
+}
+
+func TestCacheRawBody(t *testing.T) {
+	e, mdb, prefix := newTestAppWith(t, func(config *Config) {
+		config.CacheStatusHeader = "X-Ab-Cache"
+	}, &SingleModel{Model: new(testVersionModel), CacheTime: time.Minute,
+		GetSingleResponseFunc: func(ctx iris.Context, item interface{}) interface{} {
+			return []interface{}{item, 1.50}
+		}})
+	fp := prefix + "/" + mdb.TableName(new(testVersionModel))
+	row := &testVersionModel{Name: "r"}
+	_, _ = mdb.InsertOne(row)
+	fs := fmt.Sprintf("%s/%d", fp, row.Id)
+
+	miss := e.GET(fs).Expect().Status(httptest.StatusOK)
+	miss.Header("X-Ab-Cache").Equal("MISS")
+	hit := e.GET(fs).Expect().Status(httptest.StatusOK)
+	hit.Header("X-Ab-Cache").Equal("HIT")
+	// 命中时内容与header与回源一致
+	hit.Body().Equal(miss.Body().Raw())
+	hit.ContentType("application/json")
+	hit.Header("ETag").Equal(`"v1"`)
+	e.GET(fs).WithHeader("If-None-Match", `"v1"`).Expect().Status(httptest.StatusNotModified)
+}
+
+func TestCacheable(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testModel), CacheTime: time.Minute, NotFoundCacheTime: time.Minute, PrivateContextKey: "code", PrivateColName: "code",
+			CacheableFunc: func(ctx iris.Context, from string) bool {
+				return from != "list" || len(ctx.URLParam("me")) < 1
+			},
+			GetSingleResponseFunc: func(ctx iris.Context, item interface{}) interface{} {
+				if item.(*testModel).Name == "private" {
+					ctx.Header("Cache-Control", "private, max-age=10")
+				}
+				return item
+			},
+		},
+	)
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+
+	// 不存在的数据短时间缓存 新增后清除
+	e.GET(fp + "/1").Expect().Status(httptest.StatusNotFound).Header("X-Cache").Equal("MISS")
+	hit := e.GET(fp + "/1").Expect().Status(httptest.StatusNotFound)
+	hit.Header("X-Cache").Equal("HIT")
+	hit.JSON().Object().Value("code").Equal(CodeNotFoundDataFail)
+	e.POST(fp).WithForm(map[string]interface{}{"name": "a"}).Expect().Status(httptest.StatusOK)
+	e.GET(fp + "/1").Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("MISS")
+
+	// Cache-Control private 不缓存
+	e.POST(fp).WithForm(map[string]interface{}{"name": "private"}).Expect().Status(httptest.StatusOK)
+	e.GET(fp + "/2").Expect().Status(httptest.StatusOK)
+	e.GET(fp + "/2").Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("MISS")
+
+	// CacheableFunc 返回false不缓存
+	e.GET(fp).WithQuery("me", 1).Expect().Status(httptest.StatusOK)
+	e.GET(fp).WithQuery("me", 1).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("MISS")
+	e.GET(fp).Expect().Status(httptest.StatusOK)
+	e.GET(fp).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+}
+
+func TestRateLimit(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	store := NewRedisRateStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}))
+	newApp := func() (*httpexpect.Expect, string) {
+		e, mdb, prefix := newTestAppWith(t, func(config *Config) {
+			config.RateStore = store
+		}, &SingleModel{Model: new(testModel), GetAllRate: tollbooth.NewLimiter(2, nil)})
+		return e, prefix + "/" + mdb.TableName(new(testModel))
+	}
+	// 两个实例共享计数 httptest没有RemoteAddr 使用X-Real-IP
+	e1, fp := newApp()
+	e2, _ := newApp()
+	r := e1.GET(fp).WithHeader("X-Real-IP", "1.1.1.1").Expect().Status(httptest.StatusOK)
+	r.Header("X-RateLimit-Limit").Equal("2")
+	r.Header("X-RateLimit-Remaining").Equal("1")
+	e2.GET(fp).WithHeader("X-Real-IP", "1.1.1.1").Expect().Status(httptest.StatusOK).Header("X-RateLimit-Remaining").Equal("0")
+	r = e1.GET(fp).WithHeader("X-Real-IP", "1.1.1.1").Expect().Status(httptest.StatusTooManyRequests)
+	r.Header("Retry-After").Equal("1")
+	// 其他方法不受影响
+	e1.POST(fp).WithForm(map[string]interface{}{"name": "a"}).Expect().Status(httptest.StatusOK)
+
+	// 进程内计数
+	s := NewMemoryRateStore()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	if res, _ := s.Allow(_ctx.Background(), "k", limit); !res.Allowed {
+		t.Fatal("first request should be allowed")
+	}
+	if res, _ := s.Allow(_ctx.Background(), "k", limit); res.Allowed || res.RetryAfter <= 0 {
+		t.Fatal("second request should be limited")
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	// 按私密参数计数 不同ip共享
+	e, mdb, prefix := newTestApp(t, &SingleModel{Model: new(testModel), PrivateContextKey: "code", PrivateColName: "code",
+		RateByPrivate: true, GetAllRate: tollbooth.NewLimiter(1, nil)})
+	fp := prefix + "/" + mdb.TableName(new(testModel))
+	e.GET(fp).WithHeader("X-Real-IP", "1.1.1.1").Expect().Status(httptest.StatusOK)
+	e.GET(fp).WithHeader("X-Real-IP", "2.2.2.2").Expect().Status(httptest.StatusTooManyRequests)
+
+	// 自定义key 匿名与指定租户使用不同的规则
+	e, mdb, prefix = newTestApp(t, &SingleModel{Model: new(testModel),
+		RateKeyFunc: func(ctx iris.Context) string {
+			return ctx.GetHeader("X-Tenant")
+		},
+		GetAllRate:    tollbooth.NewLimiter(2, nil),
+		AnonymousRate: tollbooth.NewLimiter(1, nil),
+		RateOverrides: map[string]*limiter.Limiter{"big": tollbooth.NewLimiter(5, nil)},
+	})
+	fp = prefix + "/" + mdb.TableName(new(testModel))
+	get := func(tenant string) *httpexpect.Response {
+		return e.GET(fp).WithHeader("X-Real-IP", "1.1.1.1").WithHeader("X-Tenant", tenant).Expect()
+	}
+	get("").Status(httptest.StatusOK).Header("X-RateLimit-Limit").Equal("1")
+	get("").Status(httptest.StatusTooManyRequests)
+	get("a").Status(httptest.StatusOK).Header("X-RateLimit-Limit").Equal("2")
+	get("a").Status(httptest.StatusOK)
+	get("a").Status(httptest.StatusTooManyRequests)
+	get("b").Status(httptest.StatusOK)
+	get("big").Status(httptest.StatusOK).Header("X-RateLimit-Limit").Equal("5")
+}
+
+type testDocModel struct {
+	Id      uint64    `xorm:"autoincr pk unique" json:"id"`
+	Name    string    `xorm:"varchar(10)" json:"name" comment:"名称" validate:"required,max=10"`
+	Status  string    `json:"status" validate:"oneof=on off"`
+	Age     int       `json:"age" validate:"gte=0,lte=150"`
+	Created time.Time `xorm:"created" json:"created"`
+}
+
+type testDocResp struct {
+	Name string `json:"name"`
+}
+
+func TestOpenApi(t *testing.T) {
+	var config *Config
+	e, mdb, prefix := newTestAppWith(t, func(c *Config) {
+		c.OpenApiPath = "/openapi.json"
+		c.OpenApiInfo = OpenApiInfo{Title: "test"}
+		config = c
+	}, &SingleModel{Model: new(testDocModel), PostResponse: new(testDocResp), AllowSearchFields: []string{"Name"},
+		DisableMethods: []string{"delete"}})
+	fp := prefix + "/" + mdb.TableName(new(testDocModel))
+
+	doc := e.GET(prefix + "/openapi.json").Expect().Status(httptest.StatusOK).JSON().Object()
+	doc.Value("openapi").Equal("3.0.3")
+	doc.Path("$.info.title").Equal("test")
+	paths := doc.Value("paths").Object()
+	paths.Keys().Contains(fp, fp+"/{id}", fp+"/_bulk")
+	paths.Value(fp + "/{id}").Object().Keys().NotContains("delete")
+	post := paths.Value(fp).Object().Value("post").Object()
+	post.Value("responses").Object().Value("200").Object().Value("content").Object().
+		Value("application/json").Object().Path("$.schema.properties").Object().Keys().ContainsOnly("name")
+
+	schemas := doc.Path("$.components.schemas").Object()
+	model := schemas.Value("test_doc_model").Object()
+	model.Path("$.properties.id.readOnly").Equal(true)
+	model.Path("$.properties.name.maxLength").Equal(10)
+	model.Path("$.properties.status.enum").Array().Elements("on", "off")
+	model.Path("$.properties.age.maximum").Equal(150)
+	model.Value("required").Array().Elements("name")
+	// 请求体不包含只读列
+	schemas.Path("$.test_doc_model_input.properties").Object().Keys().ContainsOnly("name", "status", "age")
+
+	// 批量新增的返回与实际返回一致
+	bulkSchema := paths.Value(fp + "/_bulk").Object().Value("post").Object().Value("responses").Object().Value("200").Object().
+		Value("content").Object().Value("application/json").Object().Value("schema").Object()
+	bulkSchema.Value("type").Equal("object")
+	bulk := e.POST(fp + "/_bulk").WithJSON([]map[string]interface{}{{"name": "a"}}).Expect().Status(httptest.StatusOK).JSON().Object()
+	bulkSchema.Value("properties").Object().Keys().ContainsOnly(bulk.Keys().Raw()...)
+	bulkSchema.Value("required").Array().ContainsOnly(bulk.Keys().Raw()...)
+	bulkItem := bulkSchema.Path("$.properties.results.items.properties").Object()
+	bulkItem.Keys().Contains(bulk.Value("results").Array().Element(0).Object().Keys().Raw()...)
+
+	// 导出到文件
+	dir, err := ioutil.TempDir("", "ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "openapi.json")
+	if err = (&RestApi{C: config}).ExportOpenApi(fileName); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil || !bytes.Contains(b, []byte(`"operationId": "list_test_doc_model"`)) {
+		t.Fatal("export openapi fail", err)
+	}
+}
+
+type testDocValid struct {
+	Score *float64 `json:"score" validate:"gt=0"`
+}
+
+func TestJsonSchema(t *testing.T) {
+	var config *Config
+	e, mdb, prefix := newTestAppWith(t, func(c *Config) {
+		c.SchemaRoute = true
+		config = c
+	}, &SingleModel{Model: new(testDocModel), PostValidator: new(testDocValid)})
+	fp := prefix + "/" + mdb.TableName(new(testDocModel))
+
+	s := e.GET(fp + "/_schema").Expect().Status(httptest.StatusOK).JSON().Object()
+	s.Keys().ContainsOnly("create", "update", "response")
+	create := e.GET(fp+"/_schema").WithQuery("shape", "create").Expect().Status(httptest.StatusOK).JSON().Object()
+	create.Value("$schema").Equal("https://json-schema.org/draft/2020-12/schema")
+	input := create.Value("allOf").Array().Element(0).Object()
+	input.Path("$.properties.name.description").Equal("名称")
+	input.Value("required").Array().Elements("name")
+	// 可空类型与开区间按2020-12格式
+	score := create.Value("allOf").Array().Element(1).Object().Path("$.properties.score").Object()
+	score.Value("type").Array().Elements("number", "null")
+	score.Value("exclusiveMinimum").Equal(0)
+	score.NotContainsKey("minimum")
+	e.GET(fp+"/_schema").WithQuery("shape", "x").Expect().Status(httptest.StatusBadRequest)
+
+	m, err := (&RestApi{C: config}).JsonSchema(mdb.TableName(new(testDocModel)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Response["title"] != "test_doc_model" {
+		t.Fatal("response schema title fail")
+	}
+	if _, err = (&RestApi{C: config}).JsonSchema("none"); err == nil {
+		t.Fatal("unknown table should fail")
+	}
+
+	// 表名前缀相同的模型
+	e, mdb, prefix = newTestAppWith(t, func(c *Config) {
+		c.SchemaRoute = true
+	}, &SingleModel{Model: new(testPost)}, &SingleModel{Model: new(testPostTag)})
+	e.GET(prefix+"/"+mdb.TableName(new(testPostTag))+"/_schema").WithQuery("shape", "response").
+		Expect().Status(httptest.StatusOK).JSON().Object().Value("title").Equal("test_post_tag")
+}
+
+func TestTypeScript(t *testing.T) {
+	var config *Config
+	_, _, _ = newTestAppWith(t, func(c *Config) {
+		config = c
+	}, &SingleModel{Model: new(testDocModel), PostResponse: new(testDocResp), DisableMethods: []string{"delete"}})
+	ts := (&RestApi{C: config}).TypeScript()
+	for _, s := range []string{
+		`export type TestDocModelColumn = "id" | "name" | "status" | "age" | "created";`,
+		"  readonly id: number;\n",
+		"  status?: \"on\" | \"off\";\n",
+		"export type TestDocModelCreateResponse = {\n  name: string;\n};",
+		`list: (params?: ListParams<TestDocModelColumn>) => request<TestDocModelList>(options, "GET", "/api/v1/test_doc_model", toQuery(params)),`,
+		`create: (data: TestDocModelInput) => request<TestDocModelCreateResponse>(options, "POST", "/api/v1/test_doc_model", undefined, data),`,
+		`bulkCreate: (data: TestDocModelInput[]) => request<BulkResponse<TestDocModelCreateResponse>>(options, "POST", "/api/v1/test_doc_model/_bulk", undefined, data),`,
+	} {
+		if !strings.Contains(ts, s) {
+			t.Fatalf("typescript should contain %s", s)
+		}
+	}
+	if strings.Contains(ts, "delete: (id: number)") {
+		t.Fatal("disabled method should not be generated")
+	}
+}
+
+func TestNewOffline(t *testing.T) {
+	// 数据库文件所在目录不存在 ping会失败
+	mdb, err := xorm.NewEngine("sqlite3", filepath.Join(os.TempDir(), "ab-missing", "none", "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mdb.Ping() == nil {
+		t.Fatal("ping should fail")
+	}
+	api := NewOffline(&Config{
+		MysqlInstance: MysqlInstance{Mdb: mdb},
+		Models: []*SingleModel{
+			{Model: new(testDocModel), CacheTime: time.Minute, AllowSearchFields: []string{"name"}},
+		},
+	})
+	ts := api.TypeScript()
+	if !strings.Contains(ts, `"GET", "/test_doc_model", toQuery(params)`) {
+		t.Fatalf("typescript should contain model routes %s", ts)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	e, _, prefix := newTestAppWith(t, func(c *Config) {
+		c.MetaRoute = true
+		c.MetaMiddlewares = []context.Handler{func(ctx iris.Context) {
+			if ctx.GetHeader("X-Token") != "admin" {
+				ctx.StopWithStatus(iris.StatusUnauthorized)
+				return
+			}
+			ctx.Next()
+		}}
+	}, &SingleModel{Model: new(testDocModel), AllowSearchFields: []string{"Name"}, AllowSortFields: []string{"Age"},
+		AllowFilterOps: map[string][]string{"age": {"gte", "lte"}}, GetAllCacheTime: time.Minute, MaxPageSize: 50,
+		PrivateContextKey: "code", PrivateColName: "age", DisableMethods: []string{"delete", "post(bulk)"}},
+		&SingleModel{Model: new(testModel)})
+
+	e.GET(prefix + "/_meta").Expect().Status(httptest.StatusUnauthorized)
+	list := e.GET(prefix+"/_meta").WithHeader("X-Token", "admin").Expect().Status(httptest.StatusOK).JSON().Array()
+	list.Length().Equal(2)
+
+	m := e.GET(prefix+"/_meta/test_doc_model").WithHeader("X-Token", "admin").Expect().Status(httptest.StatusOK).JSON().Object()
+	m.Value("route").Equal(prefix + "/test_doc_model")
+	m.Value("methods").Array().Elements("get(all)", "get(single)", "patch", "post", "put")
+	m.Path("$.fields.fields").Array().Length().Equal(5)
+	m.Path("$.fields.fields[1].comment_tags").Equal("名称")
+	m.Value("search_fields").Array().Elements("name")
+	m.Value("sort_fields").Array().Elements("age")
+	m.Path("$.filter_ops.age").Array().Elements("eq", "gte", "lte")
+	m.Path("$.filter_ops.name").Array().Elements("eq")
+	m.Path("$.page.max_page_size").Equal(50)
+	m.Path("$.cache.list").Equal(60)
+	m.Path("$.cache.single").Equal(0)
+	m.Value("private").Equal(true)
+
+	e.GET(prefix+"/_meta/none").WithHeader("X-Token", "admin").Expect().Status(httptest.StatusNotFound)
+}
+
+type testAuthor struct {
+	Id   uint64 `xorm:"autoincr pk unique" json:"id"`
+	Name string `xorm:"varchar(10)" json:"name"`
+	Code uint64 `json:"code"`
+}
+
+type testPost struct {
+	Id       uint64 `xorm:"autoincr pk unique" json:"id"`
+	Title    string `xorm:"varchar(20)" json:"title"`
+	AuthorId uint64 `json:"author_id"`
+}
+
+type testTag struct {
+	Id   uint64 `xorm:"autoincr pk unique" json:"id"`
+	Name string `xorm:"varchar(10)" json:"name"`
+}
+
+type testPostTag struct {
+	Id     uint64 `xorm:"autoincr pk unique" json:"id"`
+	PostId uint64 `json:"post_id"`
+	TagId  uint64 `json:"tag_id"`
+}
+
+type testReply struct {
+	Id      uint64  `xorm:"autoincr pk unique" json:"id"`
+	Content string  `xorm:"varchar(20)" json:"content"`
+	PostId  *uint64 `json:"post_id"`
+}
+
+func TestExpand(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testAuthor), PrivateContextKey: "code", PrivateColName: "code", Relations: []Relation{
+			{Name: "posts", Type: RelationHasMany, Model: new(testPost), ForeignKey: "author_id"},
+		}},
+		&SingleModel{Model: new(testPost), CacheTime: time.Minute, Relations: []Relation{
+			{Name: "author", Type: RelationBelongsTo, Model: new(testAuthor), ForeignKey: "author_id"},
+			{Name: "tags", Type: RelationManyToMany, Model: new(testTag), JoinTable: "test_post_tag", ForeignKey: "post_id", OtherKey: "tag_id"},
+		}},
+		&SingleModel{Model: new(testTag)},
+		&SingleModel{Model: new(testPostTag)},
+		&SingleModel{Model: new(testReply), Relations: []Relation{
+			{Name: "post", Type: RelationBelongsTo, Model: new(testPost), ForeignKey: "post_id"},
+		}},
+	)
+	// 作者2的私密参数与请求不同 不会被加载
+	_, err := mdb.Insert(&testAuthor{Name: "a", Code: 1}, &testAuthor{Name: "b", Code: 2},
+		&testPost{Title: "p1", AuthorId: 1}, &testPost{Title: "p2", AuthorId: 1}, &testPost{Title: "p3", AuthorId: 2},
+		&testTag{Name: "go"}, &testTag{Name: "ts"},
+		&testPostTag{PostId: 1, TagId: 1}, &testPostTag{PostId: 1, TagId: 2}, &testPostTag{PostId: 2, TagId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts := prefix + "/" + mdb.TableName(new(testPost))
+
+	e.GET(posts).Expect().Status(httptest.StatusOK)
+	e.GET(posts).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+	resp := e.GET(posts).WithQuery("expand", "author,tags").Expect().Status(httptest.StatusOK)
+	resp.Header("X-Cache").Equal("MISS")
+	data := resp.JSON().Object().Value("data").Array()
+	data.Length().Equal(3)
+	data.Element(0).Object().Value("author").Object().Value("name").Equal("a")
+	data.Element(0).Object().Value("tags").Array().Length().Equal(2)
+	data.Element(1).Object().Value("tags").Array().Element(0).Object().Value("name").Equal("ts")
+	data.Element(2).Object().Value("author").Null()
+	data.Element(2).Object().Value("tags").Array().Empty()
+
+	single := e.GET(posts+"/1").WithQuery("expand", "author").Expect().Status(httptest.StatusOK).JSON().Object()
+	single.Value("title").Equal("p1")
+	single.Value("author").Object().Value("id").Equal(1)
+	single.NotContainsKey("tags")
+
+	author := e.GET(prefix+"/"+mdb.TableName(new(testAuthor))+"/1").WithQuery("expand", "posts").Expect().Status(httptest.StatusOK).JSON().Object()
+	author.Value("posts").Array().Length().Equal(2)
+
+	// 指针外键 nil时没有关联
+	postId := uint64(2)
+	if _, err = mdb.Insert(&testReply{Content: "r1", PostId: &postId}, &testReply{Content: "r2"}); err != nil {
+		t.Fatal(err)
+	}
+	replies := prefix + "/" + mdb.TableName(new(testReply))
+	e.GET(replies+"/1").WithQuery("expand", "post").Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("post").Object().Value("title").Equal("p2")
+	e.GET(replies+"/2").WithQuery("expand", "post").Expect().Status(httptest.StatusOK).JSON().Object().Value("post").Null()
+	replyList := e.GET(replies).WithQuery("expand", "post").Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Array()
+	replyList.Element(0).Object().Value("post").Object().Value("title").Equal("p2")
+	replyList.Element(1).Object().Value("post").Null()
+
+	e.GET(posts).WithQuery("expand", "none").Expect().Status(httptest.StatusBadRequest)
+	e.GET(posts+"/1").WithQuery("expand", "author,none").Expect().Status(httptest.StatusBadRequest)
+}
+
+func TestNested(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testAuthor), PrivateContextKey: "code", PrivateColName: "code"},
+		&SingleModel{Model: new(testPost), CacheTime: time.Minute, Relations: []Relation{
+			{Name: "author", Type: RelationBelongsTo, Model: new(testAuthor), ForeignKey: "author_id", Nested: true},
+		}},
+	)
+	_, err := mdb.Insert(&testAuthor{Name: "a", Code: 1}, &testAuthor{Name: "b", Code: 2}, &testPost{Title: "p", AuthorId: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := prefix + "/" + mdb.TableName(new(testAuthor)) + "/1/" + mdb.TableName(new(testPost))
+
+	// 父数据不存在或不属于当前私密参数
+	e.GET(prefix + "/test_author/2/test_post").Expect().Status(httptest.StatusNotFound)
+	e.GET(prefix + "/test_author/9/test_post").Expect().Status(httptest.StatusNotFound)
+
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Array().Empty()
+	e.GET(fp).Expect().Status(httptest.StatusOK).Header("X-Cache").Equal("HIT")
+	// 外键使用父数据主键 新增后清除列表缓存
+	e.POST(fp).WithJSON(map[string]interface{}{"title": "p2", "author_id": 2}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("author_id").Equal(1)
+	list := e.GET(fp).Expect().Status(httptest.StatusOK)
+	list.Header("X-Cache").Equal("MISS")
+	list.JSON().Object().Value("data").Array().Length().Equal(1)
+
+	// 其他父数据下的子数据
+	e.GET(fp + "/1").Expect().Status(httptest.StatusNotFound)
+	e.PATCH(fp + "/1").WithJSON(map[string]interface{}{"title": "x"}).Expect().Status(httptest.StatusNotFound)
+	e.DELETE(fp + "/1").Expect().Status(httptest.StatusNotFound)
+
+	e.GET(fp + "/2").Expect().Status(httptest.StatusOK).JSON().Object().Value("title").Equal("p2")
+	patch := e.PATCH(fp + "/2").WithJSON(map[string]interface{}{"title": "p3", "author_id": 2}).Expect().Status(httptest.StatusOK).JSON().Object()
+	patch.Value("title").Equal("p3")
+	patch.Value("author_id").Equal(1)
+	e.PUT(fp + "/2").WithJSON(map[string]interface{}{"title": "p4"}).Expect().Status(httptest.StatusOK).
+		JSON().Object().Value("author_id").Equal(1)
+	e.DELETE(fp + "/2").Expect().Status(httptest.StatusOK)
+	e.GET(fp).Expect().Status(httptest.StatusOK).JSON().Object().Value("data").Array().Empty()
+
+	// 原有路由不受影响
+	e.GET(prefix + "/test_post/1").Expect().Status(httptest.StatusOK).JSON().Object().Value("author_id").Equal(2)
+}
+
+type testNote struct {
+	Id       uint64 `xorm:"autoincr pk unique" json:"id"`
+	Title    string `xorm:"varchar(20)" json:"title"`
+	AuthorId uint64 `json:"author_id"`
+	Version  int    `xorm:"version" json:"version"`
+}
+
+func TestNestedVersionList(t *testing.T) {
+	e, mdb, prefix := newTestApp(t,
+		&SingleModel{Model: new(testAuthor)},
+		&SingleModel{Model: new(testNote), Relations: []Relation{
+			{Name: "author", Type: RelationBelongsTo, Model: new(testAuthor), ForeignKey: "author_id", Nested: true},
+		}},
+	)
+	_, _ = mdb.Insert(&testAuthor{Name: "a"}, &testAuthor{Name: "b"})
+	// 其他父数据的数据在前 主键不连续
+	for i := 0; i < 25; i++ {
+		_, _ = mdb.InsertOne(&testNote{Title: fmt.Sprintf("b%d", i), AuthorId: 2})
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = mdb.InsertOne(&testNote{Title: fmt.Sprintf("a%d", i), AuthorId: 1})
+	}
+	fp := prefix + "/" + mdb.TableName(new(testAuthor)) + "/1/" + mdb.TableName(new(testNote))
+	first := e.GET(fp).WithQuery("page_size", 2).Expect().Status(httptest.StatusOK).JSON().Object()
+	first.Value("all").Equal(3)
+	first.Value("data").Array().Length().Equal(2)
+	first.Value("data").Array().Element(0).Object().Value("title").Equal("a0")
+	second := e.GET(fp).WithQuery("page_size", 2).WithQuery("page", 2).Expect().Status(httptest.StatusOK).JSON().Object()
+	second.Value("data").Array().Length().Equal(1)
+	second.Value("data").Array().Element(0).Object().Value("title").Equal("a2")
+}
@@ -0,0 +1,98 @@
+package ab
+
+import (
+	"github.com/kataras/iris/v12"
+	"sort"
+)
+
+// 此文件主要放模型元数据相关 供通用的后台页面根据元数据生成表格与表单
+
+// modelMeta 模型的元数据
+type modelMeta struct {
+	Name         string              `json:"name"`          // 表名
+	Route        string              `json:"route"`         // 路由
+	Methods      []string            `json:"methods"`       // 开启的方法
+	Fields       tableFieldsResp     `json:"fields"`        // 字段信息 类型 comment attr validate等tag
+	SearchFields []string            `json:"search_fields"` // 可搜索的列名
+	SearchMode   string              `json:"search_mode"`   // 默认搜索模式
+	SearchModes  []string            `json:"search_modes"`  // 可选择的搜索模式
+	SortFields   []string            `json:"sort_fields"`   // 可排序的列名
+	FilterOps    map[string][]string `json:"filter_ops"`    // 列名对应可用的过滤操作符
+	Page         pageMeta            `json:"page"`          // 分页限制
+	Cache        cacheMeta           `json:"cache"`         // 缓存
+	Private      bool                `json:"private"`       // 是否按私密参数隔离数据
+	BulkEdit     bool                `json:"bulk_edit"`     // 是否开启批量修改
+	BulkDelete   bool                `json:"bulk_delete"`   // 是否开启批量删除
+}
+
+type pageMeta struct {
+	MaxPageCount int  `json:"max_page_count"` // 最大页码
+	MaxPageSize  int  `json:"max_page_size"`  // 每页最大条数
+	Cursor       bool `json:"cursor"`         // 是否使用游标分页
+}
+
+type cacheMeta struct {
+	List   float64 `json:"list"`   // 列表缓存时间 秒 0为不缓存
+	Single float64 `json:"single"` // 单条缓存时间 秒 0为不缓存
+}
+
+// meta 生成模型的元数据
+func (c *SingleModel) meta() modelMeta {
+	methods := c.getMethods()
+	sort.Strings(methods)
+	// 未配置操作符的列仅允许eq
+	filterOps := make(map[string][]string, len(c.info.FieldList.Fields))
+	for _, column := range c.info.FieldList.Fields {
+		ops := []string{opEq}
+		for _, op := range c.filterOps[column.MapName] {
+			if op != opEq {
+				ops = append(ops, op)
+			}
+		}
+		filterOps[column.MapName] = ops
+	}
+	modes := make([]string, 0)
+	if len(c.searchFields) >= 1 {
+		for _, mode := range searchModes {
+			if len(c.AllowSearchModes) < 1 || isContain(c.AllowSearchModes, mode) || mode == c.SearchMode {
+				modes = append(modes, mode)
+			}
+		}
+	}
+	maxCount, maxSize := c.getPage()
+	return modelMeta{
+		Name:         c.info.MapName,
+		Route:        c.info.FullPath,
+		Methods:      methods,
+		Fields:       c.info.FieldList,
+		SearchFields: append([]string{}, c.searchFields...),
+		SearchMode:   c.SearchMode,
+		SearchModes:  modes,
+		SortFields:   c.sortFields,
+		FilterOps:    filterOps,
+		Page:         pageMeta{MaxPageCount: maxCount, MaxPageSize: maxSize, Cursor: c.CursorPage},
+		Cache:        cacheMeta{List: c.getAllListCacheTime().Seconds(), Single: c.getSingleCacheTime().Seconds()},
+		Private:      c.private,
+		BulkEdit:     c.AllowBulkEdit,
+		BulkDelete:   c.AllowBulkDelete,
+	}
+}
+
+// MetaHandler /_meta 返回所有模型的元数据
+func (c *RestApi) MetaHandler(ctx iris.Context) {
+	result := make([]modelMeta, 0, len(c.C.Models))
+	for _, model := range c.C.Models {
+		result = append(result, model.meta())
+	}
+	_, _ = ctx.JSON(result)
+}
+
+// MetaSingleHandler /_meta/{name} 返回单个模型的元数据 name为表名
+func (c *RestApi) MetaSingleHandler(ctx iris.Context) {
+	model, err := c.tableNameGetModelInfo(ctx.Params().Get("name"))
+	if err != nil {
+		c.sendError(ctx, notFoundError(err))
+		return
+	}
+	_, _ = ctx.JSON(model.meta())
+}
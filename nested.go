@@ -0,0 +1,112 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/kataras/iris/v12"
+	"reflect"
+	"strconv"
+	"xorm.io/xorm"
+)
+
+// 此文件主要放嵌套路由相关 /post/{parent_id}/comment 子模型的数据限定在父数据下
+// 子模型的Relations中belongs_to关联设置Nested为true时注册
+
+const (
+	modelContextKey  = "_ab_model"  // 路由对应的模型
+	parentContextKey = "_ab_parent" // 嵌套路由的父数据条件
+)
+
+// parentScope 嵌套路由中父数据对应的外键条件
+type parentScope struct {
+	Col   string // 子模型中的外键列
+	Value uint64 // 父数据主键
+}
+
+// where 附加外键条件 非嵌套路由原样返回
+func (p *parentScope) where(d *xorm.Session) *xorm.Session {
+	if p == nil {
+		return d
+	}
+	return d.And(fmt.Sprintf("`%s` = ?", p.Col), p.Value)
+}
+
+// modelMiddleware 设置请求对应的模型 路径前缀相同的模型与嵌套路由无法通过路径区分
+func modelMiddleware(model *SingleModel) iris.Handler {
+	return func(ctx iris.Context) {
+		ctx.Values().Set(modelContextKey, model)
+		ctx.Next()
+	}
+}
+
+// ctxGetModel 获取请求对应的模型 由modelMiddleware设置 自定义的路由通过路径匹配
+func (c *RestApi) ctxGetModel(ctx iris.Context) *SingleModel {
+	if model, ok := ctx.Values().Get(modelContextKey).(*SingleModel); ok {
+		return model
+	}
+	return c.pathGetModel(ctx.Path())
+}
+
+// ctxParent 获取嵌套路由的父数据条件 非嵌套路由返回nil
+func ctxParent(ctx iris.Context) *parentScope {
+	p, _ := ctx.Values().Get(parentContextKey).(*parentScope)
+	return p
+}
+
+// setParentValue 把外键设置为父数据主键 新增与全量更新时使用
+func (c *SingleModel) setParentValue(instance reflect.Value, p *parentScope) bool {
+	if p == nil {
+		return true
+	}
+	for _, field := range c.info.FieldList.Fields {
+		if field.MapName != p.Col {
+			continue
+		}
+		fv := columnField(instance, field.Name)
+		if !fv.IsValid() || !fv.CanSet() {
+			return false
+		}
+		return setFormValue(fv, field.Types, strconv.FormatUint(p.Value, 10)) == nil
+	}
+	return false
+}
+
+// nestedMiddleware 校验父数据存在且符合父模型的私密参数 再设置父数据条件
+func (c *RestApi) nestedMiddleware(rel *Relation) iris.Handler {
+	parent := rel.target
+	return func(ctx iris.Context) {
+		id, err := ctx.Params().GetUint64("parent_id")
+		if err != nil {
+			c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数获取错误", err))
+			return
+		}
+		d := c.C.Mdb.Table(parent.info.MapName).Where(fmt.Sprintf("`%s` = ?", parent.primaryKey()), id)
+		if parent.private {
+			d = d.And(fmt.Sprintf("`%s` = ?", parent.PrivateColName), ctx.Values().Get(parent.PrivateContextKey))
+		}
+		has, err := d.Cols(parent.primaryKey()).Get(c.newType(parent.Model))
+		if err != nil {
+			c.sendError(ctx, storageError(CodeDataExistsFail, "获取数据是否存在发生错误", err))
+			return
+		}
+		if !has {
+			c.sendError(ctx, notFoundError(nil))
+			return
+		}
+		ctx.Values().Set(parentContextKey, &parentScope{Col: rel.ForeignKey, Value: id})
+		ctx.Next()
+	}
+}
+
+// registerNested 在父模型的路由下注册子模型的嵌套路由 需要在initRelations之后执行
+func (c *RestApi) registerNested() {
+	for _, model := range c.C.Models {
+		for i := range model.Relations {
+			rel := &model.Relations[i]
+			if !rel.Nested {
+				continue
+			}
+			api := rel.target.party.Party("/{parent_id:uint64}/"+model.info.MapName, model.Middlewares...)
+			c.handleRoutes(api, model, rel)
+		}
+	}
+}
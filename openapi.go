@@ -0,0 +1,369 @@
+package ab
+
+import (
+	"encoding/json"
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// 此文件主要放openapi 3文档生成 根据注册的模型与方法生成
+
+// OpenApiInfo 文档信息
+type OpenApiInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenApiDoc openapi文档
+type OpenApiDoc struct {
+	OpenApi    string                                  `json:"openapi"`
+	Info       OpenApiInfo                             `json:"info"`
+	Paths      map[string]map[string]*OpenApiOperation `json:"paths"`
+	Components OpenApiComponents                       `json:"components"`
+}
+
+// OpenApiComponents 公共的schema与返回
+type OpenApiComponents struct {
+	Schemas   map[string]*Schema          `json:"schemas"`
+	Responses map[string]*OpenApiResponse `json:"responses"`
+}
+
+// OpenApiOperation 单个请求
+type OpenApiOperation struct {
+	Tags        []string                    `json:"tags,omitempty"`
+	Summary     string                      `json:"summary,omitempty"`
+	OperationId string                      `json:"operationId"`
+	Parameters  []*OpenApiParameter         `json:"parameters,omitempty"`
+	RequestBody *OpenApiRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*OpenApiResponse `json:"responses"`
+}
+
+// OpenApiParameter 请求参数
+type OpenApiParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// OpenApiRequestBody 请求体
+type OpenApiRequestBody struct {
+	Required bool                     `json:"required,omitempty"`
+	Content  map[string]*OpenApiMedia `json:"content"`
+}
+
+// OpenApiResponse 返回
+type OpenApiResponse struct {
+	Ref         string                   `json:"$ref,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	Content     map[string]*OpenApiMedia `json:"content,omitempty"`
+}
+
+// OpenApiMedia 内容
+type OpenApiMedia struct {
+	Schema *Schema `json:"schema"`
+}
+
+// openApiPath iris的路径参数转换为openapi格式 /{id:uint64} -> /{id}
+func openApiPath(p string) string {
+	return strings.Replace(p, "{id:uint64}", "{id}", -1)
+}
+
+// schemaRef 引用components中的schema
+func schemaRef(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// jsonContent json内容
+func jsonContent(s *Schema) map[string]*OpenApiMedia {
+	return map[string]*OpenApiMedia{context.ContentJSONHeaderValue: {Schema: s}}
+}
+
+// okResponse 200返回
+func okResponse(s *Schema) map[string]*OpenApiResponse {
+	return map[string]*OpenApiResponse{
+		"200":     {Description: "OK", Content: jsonContent(s)},
+		"default": {Ref: "#/components/responses/Error"},
+	}
+}
+
+// inputBody 新增修改的请求体 支持json与表单 有验证器时同时需要满足验证器
+func inputBody(s *Schema, validator interface{}) *OpenApiRequestBody {
+	s = withValidator(s, validator)
+	return &OpenApiRequestBody{
+		Required: true,
+		Content: map[string]*OpenApiMedia{
+			context.ContentJSONHeaderValue:          {Schema: s},
+			context.ContentFormHeaderValue:          {Schema: s},
+			context.ContentFormMultipartHeaderValue: {Schema: s},
+		},
+	}
+}
+
+// idParameter 主键路径参数
+func idParameter() *OpenApiParameter {
+	min := float64(1)
+	return &OpenApiParameter{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "integer", Format: "int64", Minimum: &min}}
+}
+
+// queryParameter 字符串类型的query参数
+func queryParameter(name string, desc string, s *Schema) *OpenApiParameter {
+	if s == nil {
+		s = &Schema{Type: "string"}
+	}
+	return &OpenApiParameter{Name: name, In: "query", Description: desc, Schema: s}
+}
+
+// filterParameters 过滤参数 filter_[列名] or_[列名] 以及允许的操作符
+func (c *SingleModel) filterParameters() []*OpenApiParameter {
+	result := make([]*OpenApiParameter, 0)
+	for _, column := range c.info.FieldList.Fields {
+		for _, prefix := range []string{"filter_", "or_"} {
+			result = append(result, queryParameter(prefix+column.MapName, column.CommentTags, nil))
+			for _, op := range c.filterOps[column.MapName] {
+				if op == opEq {
+					continue
+				}
+				result = append(result, queryParameter(prefix+column.MapName+"__"+op, column.CommentTags, nil))
+			}
+		}
+	}
+	return result
+}
+
+// listParameters 获取列表的参数
+func (c *SingleModel) listParameters() []*OpenApiParameter {
+	integer := &Schema{Type: "integer"}
+	result := make([]*OpenApiParameter, 0)
+	if c.CursorPage {
+		result = append(result,
+			queryParameter("cursor", "游标 使用返回的next_cursor或prev_cursor", nil),
+			queryParameter("count", "是否统计总数 default 1", &Schema{Type: "boolean"}),
+		)
+	} else {
+		result = append(result, queryParameter("page", "页码", integer))
+	}
+	result = append(result,
+		queryParameter("page_size", "每页条数", integer),
+		queryParameter("sort", "排序 eg:-created,name", nil),
+		queryParameter("order", "正序字段", nil),
+		queryParameter("order_desc", "倒序字段", nil),
+	)
+	if len(c.searchFields) >= 1 {
+		modes := make([]interface{}, 0, len(searchModes))
+		for _, mode := range searchModes {
+			modes = append(modes, mode)
+		}
+		result = append(result,
+			queryParameter("search", "搜索 字段:"+strings.Join(c.searchFields, ","), nil),
+			queryParameter("search_mode", "搜索模式", &Schema{Type: "string", Enum: modes}),
+		)
+	}
+	return append(result, c.filterParameters()...)
+}
+
+// listSchema 获取列表的返回
+func (c *SingleModel) listSchema() *Schema {
+	integer := &Schema{Type: "integer"}
+	s := &Schema{Type: "object", Properties: map[string]*Schema{
+		"page_size": integer,
+		"all":       integer,
+		"data":      {Type: "array", Items: schemaRef(c.info.MapName + "_list_item")},
+	}}
+	if c.CursorPage {
+		s.Properties["next_cursor"] = &Schema{Type: "string"}
+		s.Properties["prev_cursor"] = &Schema{Type: "string"}
+	} else {
+		s.Properties["page"] = integer
+	}
+	return s
+}
+
+// resultSchema 返回内容 设置了自定义返回时使用自定义的类型 否则使用模型
+func (c *SingleModel) resultSchema(resp respItem) *Schema {
+	if s := c.respSchema(resp); s != nil {
+		return s
+	}
+	return schemaRef(c.info.MapName)
+}
+
+// operations 模型所有的请求 key为路径与方法
+func (c *SingleModel) operations() map[string]map[string]*OpenApiOperation {
+	name := c.info.MapName
+	base := c.info.FullPath
+	single := openApiPath(base + "/{id:uint64}")
+	paths := make(map[string]map[string]*OpenApiOperation)
+	add := func(p string, method string, op *OpenApiOperation) {
+		if _, ok := paths[p]; !ok {
+			paths[p] = make(map[string]*OpenApiOperation)
+		}
+		op.Tags = []string{name}
+		paths[p][method] = op
+	}
+	methods := c.getMethods()
+	affected := &Schema{Type: "object", Properties: map[string]*Schema{"affected": {Type: "integer"}}}
+	bulkParameters := func() []*OpenApiParameter {
+		return append([]*OpenApiParameter{queryParameter("ids", "主键 逗号分隔", nil)}, c.filterParameters()...)
+	}
+
+	if isContain(methods, "get(all)") {
+		add(base, "get", &OpenApiOperation{
+			Summary:     "获取列表",
+			OperationId: "list_" + name,
+			Parameters:  c.listParameters(),
+			Responses:   okResponse(c.listSchema()),
+		})
+	}
+	if isContain(methods, "get(single)") {
+		add(single, "get", &OpenApiOperation{
+			Summary:     "获取单条",
+			OperationId: "get_" + name,
+			Parameters:  []*OpenApiParameter{idParameter()},
+			Responses:   okResponse(c.resultSchema(c.singleResp)),
+		})
+	}
+	if isContain(methods, "post") {
+		add(base, "post", &OpenApiOperation{
+			Summary:     "新增",
+			OperationId: "create_" + name,
+			RequestBody: inputBody(schemaRef(name+"_input"), c.PostValidator),
+			Responses:   okResponse(c.resultSchema(c.postResp)),
+		})
+	}
+	if isContain(methods, "post(bulk)") {
+		// 与BulkAddData的返回一致 {"success":1,"fail":0,"results":[{"index":0,"data":{}}]}
+		item := &Schema{Type: "object", Required: []string{"index"}, Properties: map[string]*Schema{
+			"index": {Type: "integer"},
+			"data":  c.resultSchema(c.postResp),
+			"error": schemaRef("Error"),
+		}}
+		bulkResp := &Schema{Type: "object", Required: []string{"success", "fail", "results"}, Properties: map[string]*Schema{
+			"success": {Type: "integer"},
+			"fail":    {Type: "integer"},
+			"results": {Type: "array", Items: item},
+		}}
+		add(base+"/_bulk", "post", &OpenApiOperation{
+			Summary:     "批量新增",
+			OperationId: "bulk_create_" + name,
+			RequestBody: &OpenApiRequestBody{Required: true, Content: map[string]*OpenApiMedia{
+				context.ContentJSONHeaderValue: {Schema: &Schema{Type: "array", Items: schemaRef(name + "_input")}},
+				"application/x-ndjson":         {Schema: schemaRef(name + "_input")},
+			}},
+			Responses: okResponse(bulkResp),
+		})
+	}
+	if isContain(methods, "put") {
+		add(single, "put", &OpenApiOperation{
+			Summary:     "修改",
+			OperationId: "update_" + name,
+			Parameters:  []*OpenApiParameter{idParameter()},
+			RequestBody: inputBody(schemaRef(name+"_input"), c.PutValidator),
+			Responses:   okResponse(c.resultSchema(c.putResp)),
+		})
+	}
+	if isContain(methods, "patch") {
+		add(single, "patch", &OpenApiOperation{
+			Summary:     "部分修改",
+			OperationId: "patch_" + name,
+			Parameters:  []*OpenApiParameter{idParameter()},
+			RequestBody: inputBody(schemaRef(name+"_patch"), c.PatchValidator),
+			Responses:   okResponse(c.resultSchema(c.putResp)),
+		})
+		if c.AllowBulkEdit {
+			add(base, "patch", &OpenApiOperation{
+				Summary:     "批量部分修改",
+				OperationId: "bulk_patch_" + name,
+				Parameters:  bulkParameters(),
+				RequestBody: inputBody(schemaRef(name+"_patch"), c.PatchValidator),
+				Responses:   okResponse(affected),
+			})
+		}
+	}
+	if isContain(methods, "delete") {
+		deleted := c.respSchema(c.deleteResp)
+		if deleted == nil {
+			deleted = &Schema{Type: "object", Properties: map[string]*Schema{"id": {Type: "integer"}}}
+		}
+		add(single, "delete", &OpenApiOperation{
+			Summary:     "删除",
+			OperationId: "delete_" + name,
+			Parameters:  []*OpenApiParameter{idParameter()},
+			Responses:   okResponse(deleted),
+		})
+		if c.AllowBulkDelete {
+			add(base, "delete", &OpenApiOperation{
+				Summary:     "批量删除",
+				OperationId: "bulk_delete_" + name,
+				Parameters:  bulkParameters(),
+				Responses:   okResponse(affected),
+			})
+		}
+	}
+	return paths
+}
+
+// OpenApi 根据注册的模型生成openapi 3文档
+func (c *RestApi) OpenApi() *OpenApiDoc {
+	info := c.C.OpenApiInfo
+	if len(info.Title) < 1 {
+		info.Title = "ab"
+	}
+	if len(info.Version) < 1 {
+		info.Version = "1.0.0"
+	}
+	doc := &OpenApiDoc{
+		OpenApi: "3.0.3",
+		Info:    info,
+		Paths:   make(map[string]map[string]*OpenApiOperation),
+		Components: OpenApiComponents{
+			Schemas: map[string]*Schema{
+				"Error": {Type: "object", Properties: map[string]*Schema{
+					"code":   {Type: "string", Description: "错误码"},
+					"detail": {Type: "string", Description: "错误信息"},
+					"fields": {Type: "object", Description: "字段错误 key为字段名", AdditionalProperties: &Schema{Type: "string"}},
+				}},
+			},
+			Responses: map[string]*OpenApiResponse{
+				"Error": {Description: "错误", Content: jsonContent(schemaRef("Error"))},
+			},
+		},
+	}
+	for _, model := range c.C.Models {
+		name := model.info.MapName
+		doc.Components.Schemas[name] = model.modelSchema()
+		doc.Components.Schemas[name+"_input"] = model.inputSchema(false)
+		doc.Components.Schemas[name+"_patch"] = model.inputSchema(true)
+		doc.Components.Schemas[name+"_list_item"] = model.listItemSchema()
+		for p, ops := range model.operations() {
+			if _, ok := doc.Paths[p]; !ok {
+				doc.Paths[p] = make(map[string]*OpenApiOperation)
+			}
+			for method, op := range ops {
+				doc.Paths[p][method] = op
+			}
+		}
+	}
+	for _, s := range doc.Components.Schemas {
+		sort.Strings(s.Required)
+	}
+	return doc
+}
+
+// ExportOpenApi 导出openapi文档到文件
+func (c *RestApi) ExportOpenApi(fileName string) error {
+	b, err := json.MarshalIndent(c.OpenApi(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(fileName, b, 0644)
+}
+
+// OpenApiHandler 返回openapi文档 配置了OpenApiPath时自动注册
+func (c *RestApi) OpenApiHandler(ctx iris.Context) {
+	_, _ = ctx.JSON(c.OpenApi())
+}
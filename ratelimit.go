@@ -0,0 +1,242 @@
+package ab
+
+import (
+	"context"
+	"fmt"
+	"github.com/didip/tollbooth/v6"
+	tollerr "github.com/didip/tollbooth/v6/errors"
+	"github.com/didip/tollbooth/v6/limiter"
+	"github.com/go-redis/redis/v8"
+	"github.com/kataras/iris/v12"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 此文件主要放限流相关 使用GCRA算法 计数保存在RateStore中 多实例时使用redis共享
+
+// RateLimit 限流规则 每秒Rate个请求 最多突发Burst个
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// emission 每个请求消耗的时间
+func (l RateLimit) emission() time.Duration {
+	return time.Duration(float64(time.Second) / l.Rate)
+}
+
+// RateResult 限流结果
+type RateResult struct {
+	Allowed    bool          // 是否允许
+	Limit      int           // 突发上限
+	Remaining  int           // 剩余可用次数
+	ResetAfter time.Duration // 多久后恢复到满额
+	RetryAfter time.Duration // 被拒绝时多久后可重试
+}
+
+// RateStore 限流计数存储 可以在Config中替换为自己的实现
+type RateStore interface {
+	// Allow 按key消耗一次
+	Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error)
+}
+
+// gcra 根据理论到达时间tat计算结果 返回新的tat
+func gcra(now, tat time.Time, limit RateLimit) (RateResult, time.Time) {
+	emission := limit.emission()
+	tolerance := emission * time.Duration(limit.Burst)
+	if tat.Before(now) {
+		tat = now
+	}
+	newTat := tat.Add(emission)
+	allowAt := newTat.Add(-tolerance)
+	r := RateResult{Limit: limit.Burst}
+	if allowAt.After(now) {
+		r.ResetAfter = tat.Sub(now)
+		r.RetryAfter = allowAt.Sub(now)
+		return r, tat
+	}
+	r.Allowed = true
+	r.Remaining = int(now.Sub(allowAt) / emission)
+	r.ResetAfter = newTat.Sub(now)
+	return r, newTat
+}
+
+// MemoryRateStore 进程内限流 多实例时每个实例单独计数
+type MemoryRateStore struct {
+	mu    sync.Mutex
+	tats  map[string]time.Time
+	calls int
+}
+
+// NewMemoryRateStore 生成进程内限流存储 未配置RateStore时使用
+func NewMemoryRateStore() *MemoryRateStore {
+	return &MemoryRateStore{tats: make(map[string]time.Time)}
+}
+
+func (s *MemoryRateStore) Allow(_ context.Context, key string, limit RateLimit) (RateResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	r, tat := gcra(now, s.tats[key], limit)
+	s.tats[key] = tat
+	// 定期清理已经恢复满额的key
+	s.calls++
+	if s.calls >= 10000 {
+		s.calls = 0
+		for k, v := range s.tats {
+			if v.Before(now) {
+				delete(s.tats, k)
+			}
+		}
+	}
+	return r, nil
+}
+
+// RedisRateStore redis限流 多实例共享计数 时间以各实例的时钟为准
+type RedisRateStore struct {
+	Rdb *redis.Client
+}
+
+// NewRedisRateStore 生成redis限流存储
+func NewRedisRateStore(rdb *redis.Client) *RedisRateStore {
+	return &RedisRateStore{Rdb: rdb}
+}
+
+// redisGcraScript GCRA 保存理论到达时间 单位微秒
+// 返回 是否允许 剩余次数 恢复满额时间 重试时间
+var redisGcraScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local tolerance = emission * tonumber(ARGV[3])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+local newTat = tat + emission
+local allowAt = newTat - tolerance
+if allowAt > now then
+	return {0, 0, tat - now, allowAt - now}
+end
+redis.call('SET', KEYS[1], newTat, 'PX', math.ceil((newTat - now) / 1000))
+return {1, math.floor((now - allowAt) / emission), newTat - now, 0}
+`)
+
+func (s *RedisRateStore) Allow(ctx context.Context, key string, limit RateLimit) (RateResult, error) {
+	now := time.Now().UnixNano() / 1e3
+	v, err := redisGcraScript.Run(ctx, s.Rdb, []string{key}, now, limit.emission().Microseconds(), limit.Burst).Result()
+	if err != nil {
+		return RateResult{}, err
+	}
+	res := make([]int64, 4)
+	for i, item := range v.([]interface{}) {
+		if i < len(res) {
+			res[i], _ = item.(int64)
+		}
+	}
+	return RateResult{
+		Allowed:    res[0] == 1,
+		Limit:      limit.Burst,
+		Remaining:  int(res[1]),
+		ResetAfter: time.Duration(res[2]) * time.Microsecond,
+		RetryAfter: time.Duration(res[3]) * time.Microsecond,
+	}, nil
+}
+
+// ceilSecond 向上取整的秒数
+func ceilSecond(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// rateKey 获取请求的身份 优先使用RateKeyFunc 其次开启RateByPrivate时使用私密参数 为空时视为匿名
+func (c *SingleModel) rateKey(ctx iris.Context) string {
+	if c.RateKeyFunc != nil {
+		return c.RateKeyFunc(ctx)
+	}
+	if c.RateByPrivate && c.private {
+		if v := ctx.Values().Get(c.PrivateContextKey); v != nil {
+			return fmt.Sprintf("%v", v)
+		}
+	}
+	return ""
+}
+
+// hasRate 是否配置了限流
+func (c *SingleModel) hasRate(l *limiter.Limiter) bool {
+	return l != nil || c.AnonymousRate != nil || len(c.RateOverrides) >= 1
+}
+
+// rateMiddleware 限流中间件 规则沿用tollbooth的Limiter配置 计数使用Config.RateStore 未配置限流时返回nil
+// name为规则名称 不同规则单独计数 使用Rate时所有方法共享计数
+// 获取到身份时按身份计数 RateOverrides中配置了的身份使用单独的规则
+// 未获取到身份时按ip计数 配置了AnonymousRate时使用匿名规则
+// 返回 X-RateLimit-Limit X-RateLimit-Remaining X-RateLimit-Reset 被拒绝时返回Retry-After
+func (c *RestApi) rateMiddleware(model *SingleModel, name string, l *limiter.Limiter) iris.Handler {
+	if !model.hasRate(l) {
+		return nil
+	}
+	if l != nil && l == model.Rate {
+		name = "rate"
+	}
+	identify := model.RateKeyFunc != nil || model.RateByPrivate
+	return func(ctx iris.Context) {
+		r := ctx.Request()
+		rule, ruleName := l, name
+		var key string
+		if identify {
+			key = model.rateKey(ctx)
+		}
+		if len(key) >= 1 {
+			if o, ok := model.RateOverrides[key]; ok {
+				rule, ruleName = o, "override"
+			}
+		} else if identify && model.AnonymousRate != nil {
+			rule, ruleName = model.AnonymousRate, "anonymous"
+		}
+		if rule == nil || rule.GetMax() <= 0 {
+			ctx.Next()
+			return
+		}
+		if len(key) >= 1 {
+			key = "rate:" + genRedisKey(model.info.MapName, ruleName, "id", key)
+		} else {
+			if tollbooth.ShouldSkipLimiter(rule, r) {
+				ctx.Next()
+				return
+			}
+			keys := tollbooth.BuildKeys(rule, r)
+			key = "rate:" + genRedisKey(model.info.MapName, ruleName, strings.Join(keys[0], "|"))
+		}
+		burst := rule.GetBurst()
+		if burst < 1 {
+			burst = 1
+		}
+		result, err := c.C.RateStore.Allow(r.Context(), key, RateLimit{Rate: rule.GetMax(), Burst: burst})
+		if err != nil {
+			// 计数出错时不限流
+			c.C.ErrorTrace(err, "rate", "rate", ctx.Path())
+			ctx.Next()
+			return
+		}
+		ctx.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		ctx.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		ctx.Header("X-RateLimit-Reset", ceilSecond(result.ResetAfter))
+		if result.Allowed {
+			ctx.Next()
+			return
+		}
+		ctx.Header("Retry-After", ceilSecond(result.RetryAfter))
+		rule.ExecOnLimitReached(ctx.ResponseWriter(), r)
+		if model.RateErrorFunc != nil {
+			model.RateErrorFunc(&tollerr.HTTPError{Message: rule.GetMessage(), StatusCode: rule.GetStatusCode()}, ctx)
+			ctx.StopExecution()
+			return
+		}
+		ctx.ContentType(rule.GetMessageContentType())
+		ctx.StatusCode(rule.GetStatusCode())
+		_, _ = ctx.WriteString(rule.GetMessage())
+		ctx.StopExecution()
+	}
+}
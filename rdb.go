@@ -1,8 +1,11 @@
 package ab
 
 import (
+	"bytes"
 	"context"
+	"fmt"
 	"github.com/OneOfOne/xxhash"
+	jsoniter "github.com/json-iterator/go"
 	"github.com/jxskiss/base62"
 	"io"
 	"strconv"
@@ -10,7 +13,7 @@ import (
 	"time"
 )
 
-// 此文件主要放redis相关操作
+// 此文件主要放缓存key与缓存清除相关操作
 
 // genRedisKey 生成redis存储的key 尽量的短 所以使用 xxhash后进行base62
 // 生成key需要的参数为 所有请求参数与额外参数 额外参数可以为用户id等
@@ -28,12 +31,146 @@ func genRedisKey(ReqParams string, otherInfo ...string) string {
 	return base62.EncodeToString([]byte(strconv.FormatUint(keyInt, 10)))
 }
 
-// saveToRedis 响应体保存到redis当中
-func (c *RestApi) saveToRedis(ctx context.Context, keyName string, data string, expireTime time.Duration) error {
-	return c.C.Rdb.Set(ctx, keyName, data, expireTime).Err()
+// cacheEntryPrefix 缓存内容的前缀 没有前缀的为旧版本直接保存的响应体
+const cacheEntryPrefix = "ab1\n"
+
+// cacheEntry 缓存内容 原样保存响应体与相关header Expire之后StaleTime之内仍可返回旧数据
+type cacheEntry struct {
+	Expire       int64  `json:"e"`           // 过期时间 unix毫秒
+	Status       int    `json:"s,omitempty"` // 状态码 为空时为200 404为数据不存在
+	ContentType  string `json:"t,omitempty"` // Content-Type
+	Etag         string `json:"g,omitempty"` // ETag
+	LastModified string `json:"m,omitempty"` // Last-Modified
+	Body         []byte `json:"-"`           // 响应体
+}
+
+// stale 是否已经过期
+func (e cacheEntry) stale() bool {
+	return e.Expire >= 1 && time.Now().UnixNano()/1e6 > e.Expire
+}
+
+// encodeCacheEntry 生成缓存内容 前缀 + 元数据json + 换行 + 响应体
+func encodeCacheEntry(e cacheEntry) []byte {
+	meta, _ := jsoniter.Marshal(e)
+	b := make([]byte, 0, len(cacheEntryPrefix)+len(meta)+1+len(e.Body))
+	b = append(b, cacheEntryPrefix...)
+	b = append(b, meta...)
+	b = append(b, '\n')
+	return append(b, e.Body...)
+}
+
+// decodeCacheEntry 解析缓存内容 旧版本的内容视为未过期
+func decodeCacheEntry(b []byte) cacheEntry {
+	if !bytes.HasPrefix(b, []byte(cacheEntryPrefix)) {
+		return cacheEntry{Body: b}
+	}
+	b = b[len(cacheEntryPrefix):]
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 {
+		return cacheEntry{Body: b}
+	}
+	var e cacheEntry
+	_ = jsoniter.Unmarshal(b[:i], &e)
+	e.Body = b[i+1:]
+	return e
+}
+
+// saveToCache 响应保存到缓存当中 并登记到标签
+// 缓存实际保存expireTime+staleTime 超过expireTime后视为过期
+func (c *RestApi) saveToCache(ctx context.Context, keyName string, e cacheEntry, expireTime time.Duration, staleTime time.Duration, tags ...string) error {
+	e.Expire = time.Now().Add(expireTime).UnixNano() / 1e6
+	return c.C.Cache.Set(ctx, keyName, encodeCacheEntry(e), expireTime+staleTime, tags...)
+}
+
+// readCache 读取缓存
+func (c *RestApi) readCache(ctx context.Context, keyName string, from string) (cacheEntry, bool) {
+	b, has, err := c.C.Cache.Get(ctx, keyName)
+	if err != nil {
+		c.C.ErrorTrace(err, "read_cache", "cache", from)
+		return cacheEntry{}, false
+	}
+	if !has {
+		return cacheEntry{}, false
+	}
+	return decodeCacheEntry(b), true
 }
 
 // 删除key
-func (c *RestApi) deleteToRedis(ctx context.Context, keyName string) error {
-	return c.C.Rdb.Del(ctx, keyName).Err()
+func (c *RestApi) deleteCache(ctx context.Context, keyName string) error {
+	return c.C.Cache.Delete(ctx, keyName)
+}
+
+// listTag 列表缓存的标签 同一模型同一私密参数下的列表缓存都登记在此标签下
+func (c *SingleModel) listTag(privateValue interface{}) string {
+	return "tag:list:" + genRedisKey(c.info.MapName, c.PrivateColName, fmt.Sprintf("%v", privateValue))
+}
+
+// singleKey 单条数据的缓存key 由模型 主键 私密参数 额外过滤组成 与请求地址无关
+func (c *SingleModel) singleKey(id uint64, privateValue interface{}) string {
+	return "single:" + genRedisKey(c.info.MapName, strconv.FormatUint(id, 10), c.PrivateColName, fmt.Sprintf("%v", privateValue), c.getSingleExtraParams())
+}
+
+// singleTag 单条数据缓存的标签 批量修改删除时无法得知具体的主键 按标签清除
+func (c *SingleModel) singleTag(privateValue interface{}) string {
+	return "tag:single:" + genRedisKey(c.info.MapName, c.PrivateColName, fmt.Sprintf("%v", privateValue))
+}
+
+// notFoundTag 数据不存在的缓存的标签 新增数据后清除
+func (c *SingleModel) notFoundTag(privateValue interface{}) string {
+	return "tag:404:" + genRedisKey(c.info.MapName, c.PrivateColName, fmt.Sprintf("%v", privateValue))
+}
+
+// invalidateList 写入成功后清除列表缓存 延迟后再清除一次 避免并发读取写回旧数据
+func (c *RestApi) invalidateList(model *SingleModel, privateValue interface{}) {
+	if model.getAllListCacheTime() < 1 {
+		return
+	}
+	tag := model.listTag(privateValue)
+	if err := c.C.Cache.DeleteByTag(context.Background(), tag); err != nil {
+		c.C.ErrorTrace(err, "delete_tag", "cache", model.info.MapName)
+	}
+	go func() {
+		time.Sleep(model.getDelayDeleteTime())
+		_ = c.C.Cache.DeleteByTag(context.Background(), tag)
+	}()
+}
+
+// invalidateSingle 修改删除后清除单条数据缓存 延迟后再清除一次
+func (c *RestApi) invalidateSingle(model *SingleModel, id uint64, privateValue interface{}) {
+	if model.getSingleCacheTime() < 1 {
+		return
+	}
+	rKey := model.singleKey(id, privateValue)
+	if err := c.deleteCache(context.Background(), rKey); err != nil {
+		c.C.ErrorTrace(err, "delete", "cache", model.info.MapName)
+	}
+	go func() {
+		time.Sleep(model.getDelayDeleteTime())
+		_ = c.deleteCache(context.Background(), rKey)
+	}()
+}
+
+// invalidateSingleTag 批量修改删除后清除私密参数范围内所有单条数据缓存
+func (c *RestApi) invalidateSingleTag(model *SingleModel, privateValue interface{}) {
+	if model.getSingleCacheTime() < 1 {
+		return
+	}
+	tag := model.singleTag(privateValue)
+	if err := c.C.Cache.DeleteByTag(context.Background(), tag); err != nil {
+		c.C.ErrorTrace(err, "delete_tag", "cache", model.info.MapName)
+	}
+	go func() {
+		time.Sleep(model.getDelayDeleteTime())
+		_ = c.C.Cache.DeleteByTag(context.Background(), tag)
+	}()
+}
+
+// invalidateNotFound 新增数据后清除数据不存在的缓存 避免新数据返回404
+func (c *RestApi) invalidateNotFound(model *SingleModel, privateValue interface{}) {
+	if model.NotFoundCacheTime < 1 || model.getSingleCacheTime() < 1 {
+		return
+	}
+	if err := c.C.Cache.DeleteByTag(context.Background(), model.notFoundTag(privateValue)); err != nil {
+		c.C.ErrorTrace(err, "delete_tag", "cache", model.info.MapName)
+	}
 }
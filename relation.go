@@ -0,0 +1,262 @@
+package ab
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kataras/iris/v12"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+)
+
+// 此文件主要放模型关联相关 ?expand=author,tags 批量加载关联数据 每个关联一次查询
+
+// 关联类型
+const (
+	RelationBelongsTo  = "belongs_to"   // 本表外键指向关联表主键
+	RelationHasMany    = "has_many"     // 关联表外键指向本表主键
+	RelationManyToMany = "many_to_many" // 通过中间表关联
+)
+
+// Relation 模型关联 关联的模型需要在Config.Models中注册
+type Relation struct {
+	Name       string       // expand中使用的名称 同时作为返回内容中的key
+	Type       string       // belongs_to has_many many_to_many
+	Model      interface{}  // 关联的模型
+	ForeignKey string       // belongs_to为本表的外键列 has_many为关联表的外键列 many_to_many为中间表中指向本表的列
+	OtherKey   string       // many_to_many中间表中指向关联表的列
+	JoinTable  string       // many_to_many中间表名
+	Nested     bool         // belongs_to时注册嵌套路由 /关联模型/{parent_id}/本模型
+	target     *SingleModel //
+}
+
+// primaryKey 主键列名 单一主键或自增列
+func (c *SingleModel) primaryKey() string {
+	if len(c.info.FieldList.PrimaryKey) >= 1 {
+		return c.info.FieldList.PrimaryKey
+	}
+	return c.info.FieldList.AutoIncrement
+}
+
+// initRelations 查找关联的模型 在所有模型初始化之后执行 配置错误时panic
+func (c *RestApi) initRelations() {
+	for _, model := range c.C.Models {
+		for i := range model.Relations {
+			rel := &model.Relations[i]
+			if len(rel.Name) < 1 || rel.Model == nil {
+				panic(fmt.Sprintf("[ab] %s relation name and model must be set", model.info.MapName))
+			}
+			t := reflectType(rel.Model)
+			for _, m := range c.C.Models {
+				if reflectType(m.Model) == t {
+					rel.target = m
+					break
+				}
+			}
+			if rel.target == nil {
+				panic(fmt.Sprintf("[ab] %s relation %s model not registered", model.info.MapName, rel.Name))
+			}
+			if rel.Nested && rel.Type != RelationBelongsTo {
+				panic(fmt.Sprintf("[ab] %s relation %s nested only support belongs_to", model.info.MapName, rel.Name))
+			}
+			switch rel.Type {
+			case RelationBelongsTo, RelationHasMany:
+				if len(rel.ForeignKey) < 1 {
+					panic(fmt.Sprintf("[ab] %s relation %s foreign key must be set", model.info.MapName, rel.Name))
+				}
+			case RelationManyToMany:
+				if len(rel.ForeignKey) < 1 || len(rel.OtherKey) < 1 || len(rel.JoinTable) < 1 {
+					panic(fmt.Sprintf("[ab] %s relation %s join table and keys must be set", model.info.MapName, rel.Name))
+				}
+			default:
+				panic(fmt.Sprintf("[ab] %s relation %s type %s not support", model.info.MapName, rel.Name, rel.Type))
+			}
+		}
+	}
+}
+
+// parseExpand 解析expand参数 逗号分隔 不存在的关联返回错误
+func (c *SingleModel) parseExpand(raw string) ([]*Relation, error) {
+	if len(raw) < 1 {
+		return nil, nil
+	}
+	result := make([]*Relation, 0)
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) < 1 {
+			continue
+		}
+		var rel *Relation
+		for i := range c.Relations {
+			if c.Relations[i].Name == name {
+				rel = &c.Relations[i]
+				break
+			}
+		}
+		if rel == nil {
+			return nil, paramsError(errors.Errorf("不支持的expand %s", name))
+		}
+		result = append(result, rel)
+	}
+	return result, nil
+}
+
+// columnValue 获取数据中列的值 列表数据为map 单条数据为结构体 空值与nil指针视为没有关联
+func (c *SingleModel) columnValue(row interface{}, col string) (string, bool) {
+	if m, ok := row.(map[string]string); ok {
+		v, has := m[col]
+		return v, has && len(v) >= 1
+	}
+	v := reflect.Indirect(reflect.ValueOf(row))
+	if v.Kind() != reflect.Struct {
+		return "", false
+	}
+	for _, field := range c.info.FieldList.Fields {
+		if field.MapName != col {
+			continue
+		}
+		fv := columnField(v, field.Name)
+		if !fv.IsValid() || (fv.Kind() == reflect.Ptr && fv.IsNil()) {
+			return "", false
+		}
+		return fmt.Sprintf("%v", reflect.Indirect(fv).Interface()), true
+	}
+	return "", false
+}
+
+// uniqueValues 数据中列的值 去重 保持顺序
+func (c *SingleModel) uniqueValues(rows []interface{}, col string) []interface{} {
+	has := make(map[string]bool)
+	result := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		v, ok := c.columnValue(row, col)
+		if !ok || has[v] {
+			continue
+		}
+		has[v] = true
+		result = append(result, v)
+	}
+	return result
+}
+
+// loadRelated 按列的值批量查询关联模型的数据 遵循关联模型的私密参数与返回内容替换
+// 返回列的值对应的数据
+func (c *RestApi) loadRelated(ctx iris.Context, target *SingleModel, col string, values []interface{}) (map[string][]interface{}, error) {
+	result := make(map[string][]interface{})
+	if len(values) < 1 {
+		return result, nil
+	}
+	list := reflect.New(reflect.SliceOf(reflect.PtrTo(reflectType(target.Model))))
+	d := c.C.Mdb.Table(target.info.MapName).In(col, values...)
+	if target.private {
+		d = d.And(fmt.Sprintf("`%s` = ?", target.PrivateColName), ctx.Values().Get(target.PrivateContextKey))
+	}
+	if err := d.Find(list.Interface()); err != nil {
+		return nil, err
+	}
+	items := list.Elem()
+	for i := 0; i < items.Len(); i++ {
+		row := items.Index(i).Interface()
+		key, _ := target.columnValue(row, col)
+		var item = row
+		if target.singleResp.Has {
+			n := c.newType(target.singleResp.Instance)
+			_ = Replace(row, n)
+			item = n
+		}
+		if target.GetSingleResponseFunc != nil {
+			item = target.GetSingleResponseFunc(ctx, item)
+		}
+		result[key] = append(result[key], item)
+	}
+	return result, nil
+}
+
+// expandRelations 加载数据的关联内容 rows为列表数据或单条数据 返回每条数据对应的关联内容
+func (c *RestApi) expandRelations(ctx iris.Context, model *SingleModel, relations []*Relation, rows []interface{}) ([]map[string]interface{}, error) {
+	result := make([]map[string]interface{}, len(rows))
+	for i := range result {
+		result[i] = make(map[string]interface{}, len(relations))
+	}
+	for _, rel := range relations {
+		target := rel.target
+		switch rel.Type {
+		case RelationBelongsTo:
+			related, err := c.loadRelated(ctx, target, target.primaryKey(), model.uniqueValues(rows, rel.ForeignKey))
+			if err != nil {
+				return nil, err
+			}
+			for i, row := range rows {
+				result[i][rel.Name] = nil
+				if key, ok := model.columnValue(row, rel.ForeignKey); ok && len(related[key]) >= 1 {
+					result[i][rel.Name] = related[key][0]
+				}
+			}
+		case RelationHasMany:
+			related, err := c.loadRelated(ctx, target, rel.ForeignKey, model.uniqueValues(rows, model.primaryKey()))
+			if err != nil {
+				return nil, err
+			}
+			for i, row := range rows {
+				key, _ := model.columnValue(row, model.primaryKey())
+				items := related[key]
+				if items == nil {
+					items = make([]interface{}, 0)
+				}
+				result[i][rel.Name] = items
+			}
+		case RelationManyToMany:
+			pairs := make([]map[string]string, 0)
+			if keys := model.uniqueValues(rows, model.primaryKey()); len(keys) >= 1 {
+				var err error
+				pairs, err = c.C.Mdb.Table(rel.JoinTable).Cols(rel.ForeignKey, rel.OtherKey).In(rel.ForeignKey, keys...).QueryString()
+				if err != nil {
+					return nil, err
+				}
+			}
+			otherKeys := make([]interface{}, 0, len(pairs))
+			for _, pair := range pairs {
+				otherKeys = append(otherKeys, pair[rel.OtherKey])
+			}
+			related, err := c.loadRelated(ctx, target, target.primaryKey(), otherKeys)
+			if err != nil {
+				return nil, err
+			}
+			grouped := make(map[string][]interface{})
+			for _, pair := range pairs {
+				local := pair[rel.ForeignKey]
+				grouped[local] = append(grouped[local], related[pair[rel.OtherKey]]...)
+			}
+			for i, row := range rows {
+				key, _ := model.columnValue(row, model.primaryKey())
+				items := grouped[key]
+				if items == nil {
+					items = make([]interface{}, 0)
+				}
+				result[i][rel.Name] = items
+			}
+		}
+	}
+	return result, nil
+}
+
+// mergeJsonObject 把关联内容合并到json对象中 不是对象时原样返回
+func mergeJsonObject(body []byte, extra map[string]interface{}) ([]byte, error) {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(body, &m); err != nil || m == nil {
+		return body, nil
+	}
+	for k, v := range extra {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		m[k] = b
+	}
+	return json.Marshal(m)
+}
+
+// expandError 加载关联数据失败
+func expandError(err error) *ApiError {
+	return NewApiError(iris.StatusInternalServerError, CodeExpandFail, "加载关联数据失败", err)
+}
@@ -0,0 +1,433 @@
+package ab
+
+import (
+	"encoding/json"
+	"github.com/kataras/iris/v12"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 此文件主要放模型生成json schema相关 openapi与schema接口共用
+
+// Schema json schema 同时兼容openapi 3.0的schema对象
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     bool               `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     bool               `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// reflectType 实例的类型 指针取元素类型
+func reflectType(v interface{}) reflect.Type {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// typeSchema 根据go类型生成schema 结构体使用json tag作为属性名
+func typeSchema(t reflect.Type) *Schema {
+	if t.Kind() == reflect.Ptr {
+		s := typeSchema(t.Elem())
+		s.Nullable = true
+		return s
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		min := float64(0)
+		return &Schema{Type: "integer", Format: "int64", Minimum: &min}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	}
+	return &Schema{}
+}
+
+// structSchema 结构体的schema 匿名嵌套的字段展开 验证tag转换为约束
+func structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) >= 1 && !field.Anonymous {
+			continue
+		}
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		ft := field.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && ft.Kind() == reflect.Struct && len(name) < 1 {
+			inner := structSchema(ft)
+			for k, v := range inner.Properties {
+				s.Properties[k] = v
+			}
+			s.Required = append(s.Required, inner.Required...)
+			continue
+		}
+		if len(name) < 1 {
+			name = field.Name
+		}
+		fs := fieldSchema(field)
+		if isRequired(field) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+	return s
+}
+
+// jsonFieldName 字段的json名称 忽略的字段返回false 未设置名称时返回空
+func jsonFieldName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	return strings.Split(tag, ",")[0], true
+}
+
+// fieldSchema 字段的schema 附加comment作为描述 以及validate约束
+func fieldSchema(field reflect.StructField) *Schema {
+	s := typeSchema(field.Type)
+	if comment := field.Tag.Get("comment"); len(comment) >= 1 {
+		s.Description = comment
+	}
+	applyValidate(s, field.Tag.Get("validate"))
+	return s
+}
+
+// isRequired validate中是否包含required
+func isRequired(field reflect.StructField) bool {
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		if rule == "required" {
+			return true
+		}
+	}
+	return false
+}
+
+// applyValidate 把validate tag中常用的规则转换为schema约束 其余规则忽略
+// 字符串的min max len为长度 数组为个数 数字为大小
+func applyValidate(s *Schema, tag string) {
+	if len(tag) < 1 {
+		return
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+		var param string
+		if len(kv) == 2 {
+			param = kv[1]
+		}
+		switch kv[0] {
+		case "min", "gte", "max", "lte", "len", "gt", "lt":
+			applyRange(s, kv[0], param)
+		case "oneof":
+			for _, v := range strings.Fields(param) {
+				s.Enum = append(s.Enum, enumValue(s.Type, v))
+			}
+		case "email":
+			s.Format = "email"
+		case "url", "uri":
+			s.Format = "uri"
+		case "uuid", "uuid4":
+			s.Format = "uuid"
+		case "ip", "ipv4":
+			s.Format = "ipv4"
+		case "ipv6":
+			s.Format = "ipv6"
+		}
+	}
+}
+
+// applyRange 转换范围规则
+func applyRange(s *Schema, rule string, param string) {
+	n, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+	i := int(n)
+	switch s.Type {
+	case "string":
+		switch rule {
+		case "min", "gte":
+			s.MinLength = &i
+		case "max", "lte":
+			s.MaxLength = &i
+		case "len":
+			s.MinLength, s.MaxLength = &i, &i
+		}
+	case "array":
+		switch rule {
+		case "min", "gte":
+			s.MinItems = &i
+		case "max", "lte":
+			s.MaxItems = &i
+		case "len":
+			s.MinItems, s.MaxItems = &i, &i
+		}
+	case "integer", "number":
+		switch rule {
+		case "min", "gte":
+			s.Minimum = &n
+		case "max", "lte":
+			s.Maximum = &n
+		case "gt":
+			s.Minimum, s.ExclusiveMinimum = &n, true
+		case "lt":
+			s.Maximum, s.ExclusiveMaximum = &n, true
+		case "len":
+			s.Minimum, s.Maximum = &n, &n
+		}
+	}
+}
+
+// enumValue oneof的值按类型转换
+func enumValue(types string, v string) interface{} {
+	switch types {
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	}
+	return v
+}
+
+// modelSchema 模型的schema 与单条返回一致 自增 创建 更新 删除列为只读
+func (c *SingleModel) modelSchema() *Schema {
+	t := reflectType(c.Model)
+	s := structSchema(t)
+	s.Title = c.info.MapName
+	// 只读列 json名称与列名可能不同 通过struct名称对应
+	readOnly := make(map[string]bool)
+	fields := c.info.FieldList
+	for _, column := range fields.Fields {
+		_, created := fields.Created[column.MapName]
+		if created || column.MapName == fields.AutoIncrement || column.MapName == fields.Updated || column.MapName == fields.Deleted || column.MapName == fields.Version {
+			readOnly[column.Name] = true
+		}
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !readOnly[field.Name] {
+			continue
+		}
+		name, ok := jsonFieldName(field)
+		if !ok {
+			continue
+		}
+		if len(name) < 1 {
+			name = field.Name
+		}
+		if p, ok := s.Properties[name]; ok {
+			p.ReadOnly = true
+		}
+	}
+	// 只读列不再要求必填
+	required := make([]string, 0, len(s.Required))
+	for _, name := range s.Required {
+		if p, ok := s.Properties[name]; ok && p.ReadOnly {
+			continue
+		}
+		required = append(required, name)
+	}
+	s.Required = required
+	return s
+}
+
+// inputSchema 新增修改时请求体的schema 使用可写的列名作为属性名
+// partial为true时用于部分修改 不包含必填
+func (c *SingleModel) inputSchema(partial bool) *Schema {
+	t := reflectType(c.Model)
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for _, column := range writableColumns(c.info.FieldList) {
+		field, ok := columnStructField(t, column.Name)
+		if !ok {
+			continue
+		}
+		// 私密字段由上下文写入
+		if c.private && column.MapName == c.PrivateColName {
+			continue
+		}
+		s.Properties[column.MapName] = fieldSchema(field)
+		if !partial && isRequired(field) {
+			s.Required = append(s.Required, column.MapName)
+		}
+	}
+	return s
+}
+
+// listItemSchema 列表中每条数据的schema 列表数据直接查询 key为列名 值均为字符串
+// 设置了GetAllResponse时仅包含其中的列
+func (c *SingleModel) listItemSchema() *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	fields := c.info.FieldList.Fields
+	if c.allResp.Has {
+		fields = c.allResp.Fields
+	}
+	for _, column := range fields {
+		p := &Schema{Type: "string"}
+		if comment := column.CommentTags; len(comment) >= 1 {
+			p.Description = comment
+		}
+		s.Properties[column.MapName] = p
+	}
+	return s
+}
+
+// respSchema 自定义返回内容的schema 未设置时使用模型
+func (c *SingleModel) respSchema(resp respItem) *Schema {
+	if !resp.Has {
+		return nil
+	}
+	return typeSchema(reflectType(resp.Instance))
+}
+
+// jsonSchemaDraft json schema版本
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// ModelJsonSchema 模型的json schema 新增 修改的请求体与返回内容
+type ModelJsonSchema struct {
+	Create   map[string]interface{} `json:"create"`
+	Update   map[string]interface{} `json:"update"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// withValidator 有验证器时同时需要满足验证器
+func withValidator(s *Schema, validator interface{}) *Schema {
+	if validator == nil {
+		return s
+	}
+	return &Schema{AllOf: []*Schema{s, typeSchema(reflectType(validator))}}
+}
+
+// draft2020 转换为json schema draft 2020-12
+// nullable转换为type数组 exclusiveMinimum exclusiveMaximum转换为数值
+func draft2020(s *Schema, title string) map[string]interface{} {
+	b, _ := json.Marshal(s)
+	var m map[string]interface{}
+	_ = json.Unmarshal(b, &m)
+	fixDraft2020(m)
+	m["$schema"] = jsonSchemaDraft
+	m["title"] = title
+	return m
+}
+
+func fixDraft2020(m map[string]interface{}) {
+	if nullable, _ := m["nullable"].(bool); nullable {
+		if t, ok := m["type"].(string); ok {
+			m["type"] = []interface{}{t, "null"}
+		}
+	}
+	delete(m, "nullable")
+	for k, bound := range map[string]string{"exclusiveMinimum": "minimum", "exclusiveMaximum": "maximum"} {
+		if exclusive, _ := m[k].(bool); exclusive {
+			m[k] = m[bound]
+			delete(m, bound)
+		}
+	}
+	if props, ok := m["properties"].(map[string]interface{}); ok {
+		for _, p := range props {
+			if pm, ok := p.(map[string]interface{}); ok {
+				fixDraft2020(pm)
+			}
+		}
+	}
+	for _, k := range []string{"items", "additionalProperties"} {
+		if pm, ok := m[k].(map[string]interface{}); ok {
+			fixDraft2020(pm)
+		}
+	}
+	if all, ok := m["allOf"].([]interface{}); ok {
+		for _, p := range all {
+			if pm, ok := p.(map[string]interface{}); ok {
+				fixDraft2020(pm)
+			}
+		}
+	}
+}
+
+// jsonSchema 模型的json schema 修改使用PutValidator 返回内容优先使用GetSingleResponse
+func (c *SingleModel) jsonSchema() *ModelJsonSchema {
+	name := c.info.MapName
+	resp := c.respSchema(c.singleResp)
+	if resp == nil {
+		resp = c.modelSchema()
+	}
+	return &ModelJsonSchema{
+		Create:   draft2020(withValidator(c.inputSchema(false), c.PostValidator), name+" create"),
+		Update:   draft2020(withValidator(c.inputSchema(false), c.PutValidator), name+" update"),
+		Response: draft2020(resp, name),
+	}
+}
+
+// JsonSchema 获取模型的json schema tableName为表名
+func (c *RestApi) JsonSchema(tableName string) (*ModelJsonSchema, error) {
+	model, err := c.tableNameGetModelInfo(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return model.jsonSchema(), nil
+}
+
+// SchemaHandler /_schema 返回模型的json schema shape为create update response时仅返回对应的schema
+func (c *RestApi) SchemaHandler(ctx iris.Context) {
+	model := c.ctxGetModel(ctx)
+	s := model.jsonSchema()
+	switch ctx.URLParam("shape") {
+	case "":
+		_, _ = ctx.JSON(s)
+	case "create":
+		_, _ = ctx.JSON(s.Create)
+	case "update":
+		_, _ = ctx.JSON(s.Update)
+	case "response":
+		_, _ = ctx.JSON(s.Response)
+	default:
+		c.sendError(ctx, NewApiError(iris.StatusBadRequest, CodeParamsFail, "参数错误", nil))
+	}
+}
@@ -0,0 +1,118 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"sort"
+	"strings"
+	"xorm.io/xorm/schemas"
+)
+
+// 此文件主要放搜索相关 所有搜索均使用参数绑定
+
+// 搜索模式
+const (
+	SearchExact    = "exact"    // 完全匹配
+	SearchPrefix   = "prefix"   // 前缀匹配 like 'x%'
+	SearchSuffix   = "suffix"   // 后缀匹配 like '%x'
+	SearchContains = "contains" // 包含 like '%x%'
+	SearchFullText = "fulltext" // mysql全文索引 match against 需要AllowSearchFields上存在FULLTEXT索引
+)
+
+var searchModes = []string{SearchExact, SearchPrefix, SearchSuffix, SearchContains, SearchFullText}
+
+// searchAutoMode 未指定模式时兼容旧的__写法 __x为后缀 x__为前缀 __x__为包含 否则完全匹配
+func searchAutoMode(s string) (string, string) {
+	hasPrefix := strings.HasPrefix(s, "__")
+	s = strings.TrimPrefix(s, "__")
+	hasSuffix := strings.HasSuffix(s, "__")
+	s = strings.TrimSuffix(s, "__")
+	switch {
+	case hasPrefix && hasSuffix:
+		return SearchContains, s
+	case hasPrefix:
+		return SearchSuffix, s
+	case hasSuffix:
+		return SearchPrefix, s
+	}
+	return SearchExact, s
+}
+
+// getSearchMode 获取本次搜索的模式与搜索内容 请求指定优先 其次模型配置
+func (c *SingleModel) getSearchMode(reqMode string, search string) (string, string, error) {
+	mode := reqMode
+	if len(mode) >= 1 {
+		if !isContain(searchModes, mode) {
+			return "", "", errors.Errorf("不支持的搜索模式%s", mode)
+		}
+		if len(c.AllowSearchModes) >= 1 && !isContain(c.AllowSearchModes, mode) && mode != c.SearchMode {
+			return "", "", errors.Errorf("搜索模式%s未启用", mode)
+		}
+	} else {
+		mode = c.SearchMode
+	}
+	if len(mode) < 1 {
+		mode, search = searchAutoMode(search)
+	}
+	if mode == SearchFullText && !c.fullText {
+		return "", "", errors.New("全文搜索未启用")
+	}
+	return mode, search, nil
+}
+
+// searchSql 生成搜索语句与参数 多个字段为or关系
+func searchSql(mode string, fields []string, search string) (string, []interface{}) {
+	if mode == SearchFullText {
+		cols := make([]string, 0, len(fields))
+		for _, f := range fields {
+			cols = append(cols, fmt.Sprintf("`%s`", f))
+		}
+		return fmt.Sprintf("MATCH(%s) AGAINST (? IN NATURAL LANGUAGE MODE)", strings.Join(cols, ",")), []interface{}{search}
+	}
+	var value string
+	switch mode {
+	case SearchPrefix:
+		value = escapeLike(search) + "%"
+	case SearchSuffix:
+		value = "%" + escapeLike(search)
+	case SearchContains:
+		value = "%" + escapeLike(search) + "%"
+	}
+	sqlList := make([]string, 0, len(fields))
+	args := make([]interface{}, 0, len(fields))
+	for _, f := range fields {
+		if mode == SearchExact {
+			sqlList = append(sqlList, fmt.Sprintf("`%s` = ?", f))
+			args = append(args, search)
+			continue
+		}
+		sqlList = append(sqlList, likeSql(f))
+		args = append(args, value)
+	}
+	return "(" + strings.Join(sqlList, " OR ") + ")", args
+}
+
+// hasFullTextIndex 判断表中是否存在与字段完全一致的FULLTEXT索引 仅支持mysql 离线时不查询
+func (c *RestApi) hasFullTextIndex(tableName string, fields []string) bool {
+	if len(fields) < 1 || c.offline || c.C.Mdb.Dialect().URI().DBType != schemas.MYSQL {
+		return false
+	}
+	rows, err := c.C.Mdb.QueryString("SELECT INDEX_NAME, COLUMN_NAME FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_TYPE = 'FULLTEXT'", tableName)
+	if err != nil {
+		c.C.ErrorTrace(err, "fulltext_index", "mysql", tableName)
+		return false
+	}
+	indexes := make(map[string][]string, 0)
+	for _, row := range rows {
+		indexes[row["INDEX_NAME"]] = append(indexes[row["INDEX_NAME"]], row["COLUMN_NAME"])
+	}
+	want := append([]string{}, fields...)
+	sort.Strings(want)
+	for _, cols := range indexes {
+		sort.Strings(cols)
+		if strings.Join(cols, ",") == strings.Join(want, ",") {
+			return true
+		}
+	}
+	return false
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/kataras/iris/v12"
 	"github.com/kataras/iris/v12/context"
 	"github.com/pkg/errors"
+	"sort"
 	"strings"
 	"time"
 	"xorm.io/xorm"
@@ -20,14 +21,23 @@ type SingleModel struct {
 	Suffix                string                                                                         // 路由后缀
 	Model                 interface{}                                                                    // xorm model
 	info                  modelInfo                                                                      //
+	party                 iris.Party                                                                     // 模型的路由 嵌套路由注册在父模型路由下
 	private               bool                                                                           // 当有context key 以及col name时为true
 	PrivateContextKey     string                                                                         // 上下文key string int uint
 	PrivateColName        string                                                                         // 数据库字段名 MapName or ColName is ok
 	privateMapName        string                                                                         // 根据colName 找到真实的map name
 	AllowMethods          []string                                                                       // allow methods first
-	DisableMethods        []string                                                                       // get(all) get(single) post put delete
+	DisableMethods        []string                                                                       // get(all) get(single) post post(bulk) put patch delete
 	AllowSearchFields     []string                                                                       // 搜索的字段 struct名称
 	searchFields          []string                                                                       // allow search col names
+	SearchMode            string                                                                         // 默认搜索模式 exact prefix suffix contains fulltext 为空时根据__判断
+	AllowSearchModes      []string                                                                       // 允许请求通过search_mode选择的模式 为空则均可选择
+	fullText              bool                                                                           // 搜索字段上存在FULLTEXT索引
+	AllowFilterOps        map[string][]string                                                            // 字段允许的过滤操作符 key为struct名称或列名 *代表所有字段 未配置仅允许eq
+	filterOps             map[string][]string                                                            // 列名对应允许的过滤操作符
+	AllowSortFields       []string                                                                       // 允许排序的字段 struct名称或列名 为空则所有字段均可排序
+	sortFields            []string                                                                       // allow sort col names
+	Relations             []Relation                                                                     // 模型关联 通过?expand=name加载 多个逗号分隔
 	GetAllFunc            func(ctx iris.Context)                                                         // 覆盖获取全部方法
 	GetAllResponse        interface{}                                                                    // 获取所有返回的内容替换 仅替换data数组 同名替换
 	GetAllResponseFunc    func(ctx iris.Context, result iris.Map, dataList []map[string]string) iris.Map // 返回内容替换的方法
@@ -45,10 +55,21 @@ type SingleModel struct {
 	PostResponse          interface{}                                                                    // 新增返回内容
 	PostDataParse         func(ctx iris.Context, raw interface{}) interface{}                            //
 	postResp              respItem                                                                       //
+	PostBulkFunc          func(ctx iris.Context)                                                         // 覆盖批量新增方法
+	BulkBestEffort        bool                                                                           // 批量新增跳过失败的条目 默认全部成功或全部失败
+	MaxBulkSize           int                                                                            // 单次批量新增的最大条数 default 1000
+	MaxBulkBodySize       int64                                                                          // 批量新增请求体的最大字节数 default 10MB
+	BulkTxSize            int                                                                            // 批量新增每条多行insert的条数 跳过失败条目时也是每个事务的条数 default 100
+	AllowBulkEdit         bool                                                                           // 开启批量部分更新 patch / 按过滤条件或ids更新
+	AllowBulkDelete       bool                                                                           // 开启批量删除 delete / 按过滤条件或ids删除
+	MaxBulkAffected       int                                                                            // 批量更新与删除最多影响的条数 超过时不执行 default 1000
 	PutFunc               func(ctx iris.Context)                                                         // 覆盖修改方法
 	PutValidator          interface{}                                                                    // 修改验证器
 	PutResponse           interface{}                                                                    // 修改返回内容
 	putResp               respItem                                                                       //
+	PatchFunc             func(ctx iris.Context)                                                         // 覆盖部分修改方法
+	PatchValidator        interface{}                                                                    // 部分修改验证器 返回内容使用PutResponse
+	RequireIfMatch        bool                                                                           // 存在version列时 put patch delete 必须携带If-Match 否则返回428
 	DeleteFunc            func(ctx iris.Context)                                                         // 覆盖删除方法
 	DeleteValidator       interface{}                                                                    // 删除验证器
 	DeleteResponse        interface{}                                                                    // 删除返回内容
@@ -57,8 +78,12 @@ type SingleModel struct {
 	GetAllCacheTime       time.Duration                                                                  // get all cache time
 	GetSingleCacheTime    time.Duration                                                                  // get single cache time
 	DelayDeleteTime       time.Duration                                                                  // 延迟多久双删 default 500ms
+	StaleTime             time.Duration                                                                  // 缓存过期后仍可返回旧数据的时间 期间由一个请求刷新
+	NotFoundCacheTime     time.Duration                                                                  // 单条数据不存在时的缓存时间 防止扫描不存在的id 为0则不缓存
+	CacheableFunc         func(ctx iris.Context, from string) bool                                       // 回源后判断响应是否可以缓存 from为list或single 返回false则不缓存
 	MaxPageSize           int                                                                            // max page size limit
 	MaxPageCount          int                                                                            // max page count limit
+	CursorPage            bool                                                                           // 使用游标分页 cursor参数翻页 返回next_cursor prev_cursor 不受MaxPageCount限制
 	RateErrorFunc         func(*tollerr.HTTPError, iris.Context)                                         //
 	Rate                  *limiter.Limiter                                                               // all
 	GetAllRate            *limiter.Limiter                                                               //
@@ -66,6 +91,10 @@ type SingleModel struct {
 	AddRate               *limiter.Limiter                                                               //
 	PutRate               *limiter.Limiter                                                               //
 	DeleteRate            *limiter.Limiter                                                               //
+	RateByPrivate         bool                                                                           // 限流按私密参数计数 而不是ip
+	RateKeyFunc           func(ctx iris.Context) string                                                  // 自定义限流计数的key 返回空字符串视为匿名 优先于RateByPrivate
+	AnonymousRate         *limiter.Limiter                                                               // 开启按身份限流时 匿名请求使用的限流 为空则使用对应方法的限流
+	RateOverrides         map[string]*limiter.Limiter                                                    // 指定身份使用的限流 如租户 覆盖所有方法的限流
 }
 
 // getMethods 初始化请求方法 返回数组
@@ -82,6 +111,15 @@ func (c *SingleModel) getMethods() []string {
 			}
 		}
 	}
+	// 默认开启的patch与批量新增跟随put与post 避免禁用或覆盖后被绕过 需要时在AllowMethods中显式开启
+	// 禁用put或只覆盖了put的方法与验证器时不开启patch
+	if _, ok := m["put"]; !ok || (c.PutFunc != nil && c.PatchFunc == nil) || (c.PutValidator != nil && c.PatchValidator == nil) {
+		delete(m, "patch")
+	}
+	// 批量新增跟随post 覆盖了PostFunc时需要同时提供PostBulkFunc
+	if _, ok := m["post"]; !ok || (c.PostFunc != nil && c.PostBulkFunc == nil) {
+		delete(m, "post(bulk)")
+	}
 	result := make([]string, 0, len(m))
 	for k := range m {
 		result = append(result, k)
@@ -91,12 +129,14 @@ func (c *SingleModel) getMethods() []string {
 
 // initMethods 初始化请求方法 返回map
 func (c *SingleModel) initMethods() map[string]string {
-	// get(all) get(single) post put delete
+	// get(all) get(single) post post(bulk) put patch delete
 	return map[string]string{
 		"get(all)":    "get(all)",
 		"get(single)": "get(single)",
 		"post":        "post",
+		"post(bulk)":  "post(bulk)",
 		"put":         "put",
+		"patch":       "patch",
 		"delete":      "delete",
 	}
 }
@@ -114,6 +154,38 @@ func (c *SingleModel) getPage() (int, int) {
 	return maxPageCount, maxPageSize
 }
 
+// getMaxBulkSize 获取单次批量新增的最大条数
+func (c *SingleModel) getMaxBulkSize() int {
+	if c.MaxBulkSize >= 1 {
+		return c.MaxBulkSize
+	}
+	return 1000
+}
+
+// getMaxBulkBodySize 获取批量新增请求体的最大字节数
+func (c *SingleModel) getMaxBulkBodySize() int64 {
+	if c.MaxBulkBodySize >= 1 {
+		return c.MaxBulkBodySize
+	}
+	return 10 << 20
+}
+
+// getMaxBulkAffected 获取批量更新与删除最多影响的条数
+func (c *SingleModel) getMaxBulkAffected() int {
+	if c.MaxBulkAffected >= 1 {
+		return c.MaxBulkAffected
+	}
+	return 1000
+}
+
+// getBulkTxSize 获取批量新增每条多行insert的条数
+func (c *SingleModel) getBulkTxSize() int {
+	if c.BulkTxSize >= 1 {
+		return c.BulkTxSize
+	}
+	return 100
+}
+
 // getDelayDeleteTime 获取延迟删除时间
 func (c *SingleModel) getDelayDeleteTime() time.Duration {
 	if c.DelayDeleteTime >= 1 {
@@ -140,20 +212,26 @@ func (c *SingleModel) getSingleCacheTime() time.Duration {
 
 // getAllExtraParams 额外参数解析成url形式
 func (c *SingleModel) getAllExtraParams() string {
-	var s strings.Builder
-	for k, v := range c.GetAllExtraFilters {
-		s.WriteString(fmt.Sprintf("%s=%s", k, v))
-	}
-	return s.String()
+	return extraParams(c.GetAllExtraFilters)
 }
 
 // getSingleExtraParams 额外参数解析成url形式
 func (c *SingleModel) getSingleExtraParams() string {
-	var s strings.Builder
-	for k, v := range c.GetSingleExtraFilters {
-		s.WriteString(fmt.Sprintf("%s=%s", k, v))
+	return extraParams(c.GetSingleExtraFilters)
+}
+
+// extraParams 额外参数按key排序后解析成url形式 保证生成的缓存key一致
+func extraParams(filters map[string]string) string {
+	keys := make([]string, 0, len(filters))
+	for k := range filters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	s := make([]string, 0, len(keys))
+	for _, k := range keys {
+		s = append(s, fmt.Sprintf("%s=%s", k, filters[k]))
 	}
-	return s.String()
+	return strings.Join(s, "&")
 }
 
 // getAllRate get(all) rate
@@ -180,7 +258,7 @@ func (c *SingleModel) getAddRate() *limiter.Limiter {
 	return c.Rate
 }
 
-// getEditRate get put rate
+// getEditRate get put patch rate
 func (c *SingleModel) getEditRate() *limiter.Limiter {
 	if c.PutRate != nil {
 		return c.PutRate
@@ -220,6 +298,15 @@ type MysqlInstance struct {
 }
 
 func (c *MysqlInstance) check() {
+	c.init()
+	err := c.ping()
+	if err != nil {
+		panic(errors.Wrap(err, "[mysql] connect ping fail"))
+	}
+}
+
+// init 没有engine时按配置创建 xorm创建engine时不会连接数据库
+func (c *MysqlInstance) init() {
 	if c.Mdb == nil {
 		if len(c.MysqlConfig.Host) < 1 {
 			panic("[mysql] config mysql config or engine instance must be need")
@@ -227,10 +314,6 @@ func (c *MysqlInstance) check() {
 			c.connect()
 		}
 	}
-	err := c.ping()
-	if err != nil {
-		panic(errors.Wrap(err, "[mysql] connect ping fail"))
-	}
 }
 func (c *MysqlInstance) connect() {
 	// database 连接器
@@ -290,8 +373,18 @@ type Config struct {
 	Party iris.Party
 	MysqlInstance
 	RedisInstance
-	Models     []*SingleModel
-	ErrorTrace func(err error, event, from, router string) // error trace func
+	Models            []*SingleModel
+	Cache             Cache                                       // 缓存实现 为空且启用了缓存时使用redis NewMemoryCache NewTieredCache
+	CacheLock         bool                                        // 多实例时使用分布式锁合并回源 缓存需要实现Locker
+	CacheStatusHeader string                                      // 缓存状态header名 值为HIT MISS STALE default X-Cache 设置为-时不返回
+	RateStore         RateStore                                   // 限流计数存储 default NewMemoryRateStore 多实例时使用NewRedisRateStore
+	ErrorTrace        func(err error, event, from, router string) // error trace func
+	ProblemJson       bool                                        // 错误按RFC 7807 application/problem+json返回
+	OpenApiPath       string                                      // openapi文档的路由 相对Party 为空则不注册 eg:/openapi.json
+	OpenApiInfo       OpenApiInfo                                 // openapi文档的标题 版本 描述
+	SchemaRoute       bool                                        // 为每个模型注册 /_schema 返回json schema draft 2020-12
+	MetaRoute         bool                                        // 在Party上注册 /_meta 返回模型的元数据 供后台页面使用
+	MetaMiddlewares   []context.Handler                           // /_meta 的中间件 用于鉴权
 }
 
 type modelInfo struct {
@@ -301,7 +394,9 @@ type modelInfo struct {
 }
 
 type RestApi struct {
-	C *Config
+	C       *Config
+	flight  *flightGroup // 缓存回源合并
+	offline bool         // 不连接数据库与redis 只用于导出文档
 }
 
 // 模型信息
@@ -322,6 +417,7 @@ type tableFieldsResp struct {
 	Deleted       string          `json:"deleted"`
 	Created       map[string]bool `json:"created"`
 	Version       string          `json:"version"`
+	PrimaryKey    string          `json:"pk"` // 单一主键时的列名
 }
 
 type respItem struct {
@@ -0,0 +1,92 @@
+package ab
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// 此文件主要放列表排序相关解析
+// sort=-created,name,+id -为倒序 +或不带为正序
+
+const sortMaxLen = 5 // 最多排序字段数量
+
+// sortItem 单个排序字段
+type sortItem struct {
+	Col  string // 数据库列名
+	Desc bool   // 是否倒序
+}
+
+// parseSort 解析排序参数 字段必须在允许列表中
+func parseSort(raw string, allow []string) ([]sortItem, error) {
+	result := make([]sortItem, 0)
+	if len(raw) < 1 {
+		return result, nil
+	}
+	parts := strings.Split(raw, ",")
+	if len(parts) > sortMaxLen {
+		return nil, errors.Errorf("最多支持%d个排序字段", sortMaxLen)
+	}
+	for _, p := range parts {
+		p = strings.Trim(p, " ")
+		if len(p) < 1 {
+			continue
+		}
+		var item sortItem
+		switch p[0] {
+		case '-':
+			item.Desc = true
+			p = p[1:]
+		case '+':
+			p = p[1:]
+		}
+		if !isContain(allow, p) {
+			return nil, errors.Errorf("排序字段%s不存在或不允许排序", p)
+		}
+		for _, s := range result {
+			if s.Col == p {
+				return nil, errors.Errorf("排序字段%s重复", p)
+			}
+		}
+		item.Col = p
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// sortToSql 生成order by语句
+func sortToSql(items []sortItem) string {
+	s := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Desc {
+			s = append(s, fmt.Sprintf("`%s` DESC", item.Col))
+		} else {
+			s = append(s, fmt.Sprintf("`%s` ASC", item.Col))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// sortToString 转换回url参数形式 用于返回
+func sortToString(items []sortItem) string {
+	s := make([]string, 0, len(items))
+	for _, item := range items {
+		if item.Desc {
+			s = append(s, "-"+item.Col)
+		} else {
+			s = append(s, item.Col)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+// parseAllowSortFields 把模型配置的排序字段转换为列名 未配置时所有字段均可排序
+func parseAllowSortFields(setting []string, fields []structInfo) []string {
+	result := make([]string, 0, len(fields))
+	for _, field := range fields {
+		if len(setting) < 1 || isContain(setting, field.Name) || isContain(setting, field.MapName) {
+			result = append(result, field.MapName)
+		}
+	}
+	return result
+}
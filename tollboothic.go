@@ -7,6 +7,9 @@ import (
 	"github.com/kataras/iris/v12"
 )
 
+// LimitHandler 使用tollbooth限流的中间件 每个实例单独计数
+//
+// Deprecated: 模型的限流使用SingleModel.Rate等配置 由Config.RateStore计数 可以多实例共享
 func LimitHandler(l *limiter.Limiter, errBack ...func(*errors.HTTPError, iris.Context)) iris.Handler {
 	return func(ctx iris.Context) {
 		httpError := tollbooth.LimitByRequest(l, ctx.ResponseWriter(), ctx.Request())
@@ -1,12 +1,21 @@
 package ab
 
 import (
+	"github.com/kataras/iris/v12"
+	"github.com/kataras/iris/v12/context"
 	"github.com/pkg/errors"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// writeJson 直接写入已经序列化好的json
+func writeJson(ctx iris.Context, body []byte) {
+	ctx.ContentType(context.ContentJSONHeaderValue)
+	_, _ = ctx.Write(body)
+}
+
 // 字符串转换成bool
 func parseBool(str string) (bool, error) {
 	switch str {
@@ -22,6 +31,46 @@ func IsNum(s string) bool {
 	return err == nil
 }
 
+// 支持的时间格式
+var timeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02", time.RFC3339}
+
+// parseTime 字符串转换成时间 数字为unix时间戳
+func parseTime(content string) (time.Time, error) {
+	if IsNum(content) {
+		d, err := strconv.ParseInt(content, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "time change to int error")
+		}
+		return time.Unix(d, 0), nil
+	}
+	var err error
+	for _, layout := range timeLayouts {
+		var t time.Time
+		t, err = time.ParseInLocation(layout, content, time.Local)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Wrap(err, "time parse location error")
+}
+
+// parseFieldValue 根据字段类型把字符串解析成对应的值
+func parseFieldValue(types string, content string) (interface{}, error) {
+	switch strings.TrimPrefix(types, "*") {
+	case "int", "int8", "int16", "int32", "int64", "time.Duration":
+		return strconv.ParseInt(content, 10, 64)
+	case "uint", "uint8", "uint16", "uint32", "uint64":
+		return strconv.ParseUint(content, 10, 64)
+	case "float32", "float64":
+		return strconv.ParseFloat(content, 64)
+	case "bool":
+		return parseBool(content)
+	case "time", "time.Time":
+		return parseTime(content)
+	}
+	return content, nil
+}
+
 func isContain(items []string, item string) bool {
 	for _, eachItem := range items {
 		if eachItem == item {
@@ -31,33 +80,15 @@ func isContain(items []string, item string) bool {
 	return false
 }
 
-func filterMatch(fullParams map[string]string, fields []structInfo) (map[string]string, map[string]string) {
-	filter := make(map[string]string, 0)
-	or := make(map[string]string, 0)
-	for k, v := range fullParams {
-		if strings.HasPrefix(k, "filter_") {
-			for _, field := range fields {
-				if field.MapName == strings.Replace(k, "filter_", "", 1) {
-					// 为了安全 长度限制一下
-					if len(v) > 64 {
-						break
-					}
-					filter[field.MapName] = strings.Trim(v, " ")
-				}
-			}
-		} else if strings.HasPrefix(k, "or_") {
-			for _, field := range fields {
-				if field.MapName == strings.Replace(k, "or_", "", 1) {
-					// 为了安全 长度限制一下
-					if len(v) > 64 {
-						break
-					}
-					or[field.MapName] = strings.Trim(v, " ")
-				}
-			}
+// removeItem 删除数组中的某项 返回新数组
+func removeItem(items []string, item string) []string {
+	result := make([]string, 0, len(items))
+	for _, eachItem := range items {
+		if eachItem != item {
+			result = append(result, eachItem)
 		}
 	}
-	return filter, or
+	return result
 }
 
 func IsZeroOfUnderlyingType(x interface{}) bool {
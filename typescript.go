@@ -0,0 +1,343 @@
+package ab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// 此文件主要放typescript类型与请求客户端生成 类型与openapi使用相同的schema
+
+// tsName 表名转换为类型名 test_model -> TestModel
+func tsName(mapName string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(mapName, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+// tsLowerName 客户端中模型的属性名 test_model -> testModel
+func tsLowerName(mapName string) string {
+	name := tsName(mapName)
+	if len(name) < 1 {
+		return name
+	}
+	return strings.ToLower(name[:1]) + name[1:]
+}
+
+// tsKey 属性名 非标识符时加引号
+func tsKey(name string) string {
+	for i, r := range name {
+		if r == '_' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return fmt.Sprintf("%q", name)
+	}
+	return name
+}
+
+// tsType schema转换为typescript类型
+func tsType(s *Schema, indent string) string {
+	var t string
+	switch {
+	case len(s.Ref) >= 1:
+		t = tsName(strings.TrimPrefix(s.Ref, "#/components/schemas/"))
+	case len(s.AllOf) >= 1:
+		parts := make([]string, 0, len(s.AllOf))
+		for _, item := range s.AllOf {
+			parts = append(parts, tsType(item, indent))
+		}
+		t = strings.Join(parts, " & ")
+	case len(s.Enum) >= 1:
+		parts := make([]string, 0, len(s.Enum))
+		for _, v := range s.Enum {
+			if str, ok := v.(string); ok {
+				parts = append(parts, fmt.Sprintf("%q", str))
+			} else {
+				parts = append(parts, fmt.Sprintf("%v", v))
+			}
+		}
+		t = strings.Join(parts, " | ")
+	case s.Type == "integer" || s.Type == "number":
+		t = "number"
+	case s.Type == "boolean":
+		t = "boolean"
+	case s.Type == "string":
+		t = "string"
+	case s.Type == "array":
+		t = "Array<" + tsType(s.Items, indent) + ">"
+	case s.Type == "object" && s.Properties != nil:
+		t = tsObject(s, indent)
+	case s.Type == "object" && s.AdditionalProperties != nil:
+		t = "Record<string, " + tsType(s.AdditionalProperties, indent) + ">"
+	default:
+		t = "unknown"
+	}
+	if s.Nullable {
+		t += " | null"
+	}
+	return t
+}
+
+// tsObject 对象类型 必填的属性不加? 属性按名称排序
+func tsObject(s *Schema, indent string) string {
+	if len(s.Properties) < 1 {
+		return "Record<string, unknown>"
+	}
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString("{\n")
+	inner := indent + "  "
+	for _, name := range names {
+		p := s.Properties[name]
+		if len(p.Description) >= 1 {
+			b.WriteString(inner + "/** " + p.Description + " */\n")
+		}
+		b.WriteString(inner)
+		if p.ReadOnly {
+			b.WriteString("readonly ")
+		}
+		b.WriteString(tsKey(name))
+		if !isContain(s.Required, name) {
+			b.WriteString("?")
+		}
+		b.WriteString(": " + tsType(p, inner) + ";\n")
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// allRequired 返回内容的所有属性都会返回 均设置为必填
+func allRequired(s *Schema) *Schema {
+	if s.Type != "object" || len(s.Properties) < 1 {
+		return s
+	}
+	n := *s
+	n.Required = make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		n.Required = append(n.Required, name)
+	}
+	return &n
+}
+
+// tsRuntime 客户端的公共部分
+const tsRuntime = `export type FilterOp = "eq" | "ne" | "gt" | "gte" | "lt" | "lte" | "in" | "nin" | "isnull" | "contains" | "startswith" | "endswith";
+export type FilterValue = string | number | boolean;
+export type Filter<C extends string> = Partial<Record<C, FilterValue | Partial<Record<FilterOp, FilterValue | FilterValue[]>>>>;
+export type SearchMode = "exact" | "prefix" | "suffix" | "contains" | "fulltext";
+export type SortField<C extends string> = C | ` + "`-${C}`" + `;
+
+export interface ListParams<C extends string> {
+  page?: number;
+  page_size?: number;
+  cursor?: string;
+  count?: boolean;
+  sort?: SortField<C>[];
+  search?: string;
+  search_mode?: SearchMode;
+  filter?: Filter<C>;
+  or?: Filter<C>;
+}
+
+export interface BulkParams<C extends string> {
+  ids?: number[];
+  filter?: Filter<C>;
+  or?: Filter<C>;
+}
+
+export interface BulkResult<T> {
+  index: number;
+  data?: T;
+  error?: ErrorBody;
+}
+
+export interface BulkResponse<T> {
+  success: number;
+  fail: number;
+  results: BulkResult<T>[];
+}
+
+export interface ErrorBody {
+  code: string;
+  detail: string;
+  fields?: Record<string, string>;
+}
+
+export class ApiError extends Error {
+  status: number;
+  body: ErrorBody;
+
+  constructor(status: number, body: ErrorBody) {
+    super(body.detail || String(status));
+    this.status = status;
+    this.body = body;
+  }
+}
+
+export interface ClientOptions {
+  baseUrl?: string;
+  headers?: Record<string, string> | (() => Record<string, string>);
+  fetch?: typeof fetch;
+}
+
+type Query = Record<string, string>;
+
+function filterQuery(prefix: string, filter: Record<string, unknown> | undefined, query: Query): void {
+  if (!filter) {
+    return;
+  }
+  for (const [col, v] of Object.entries(filter)) {
+    if (v === undefined) {
+      continue;
+    }
+    if (v !== null && typeof v === "object" && !Array.isArray(v)) {
+      for (const [op, opValue] of Object.entries(v as Record<string, unknown>)) {
+        const key = op === "eq" ? prefix + col : prefix + col + "__" + op;
+        query[key] = Array.isArray(opValue) ? opValue.join(",") : String(opValue);
+      }
+      continue;
+    }
+    query[prefix + col] = String(v);
+  }
+}
+
+function toQuery(params: ListParams<string> & BulkParams<string> = {}): Query {
+  const query: Query = {};
+  for (const [k, v] of Object.entries(params)) {
+    if (v === undefined || k === "filter" || k === "or") {
+      continue;
+    }
+    query[k] = Array.isArray(v) ? v.join(",") : String(v);
+  }
+  filterQuery("filter_", params.filter as Record<string, unknown>, query);
+  filterQuery("or_", params.or as Record<string, unknown>, query);
+  return query;
+}
+
+function request<T>(options: ClientOptions, method: string, path: string, query?: Query, body?: unknown): Promise<T> {
+  const qs = query && Object.keys(query).length > 0 ? "?" + new URLSearchParams(query).toString() : "";
+  const headers: Record<string, string> = { Accept: "application/json" };
+  if (body !== undefined) {
+    headers["Content-Type"] = "application/json";
+  }
+  Object.assign(headers, typeof options.headers === "function" ? options.headers() : options.headers);
+  const f = options.fetch || fetch;
+  return f((options.baseUrl || "") + path + qs, {
+    method,
+    headers,
+    body: body === undefined ? undefined : JSON.stringify(body),
+  }).then((resp) => {
+    return resp.text().then((text) => {
+      const data = text ? JSON.parse(text) : undefined;
+      if (!resp.ok) {
+        throw new ApiError(resp.status, data || { code: "", detail: resp.statusText });
+      }
+      return data as T;
+    });
+  });
+}
+`
+
+// modelTypeScript 模型的类型定义与客户端方法
+func (c *SingleModel) modelTypeScript() (string, string) {
+	name := tsName(c.info.MapName)
+	var types strings.Builder
+	columns := make([]string, 0, len(c.info.FieldList.Fields))
+	for _, column := range c.info.FieldList.Fields {
+		columns = append(columns, fmt.Sprintf("%q", column.MapName))
+	}
+	if len(columns) < 1 {
+		columns = append(columns, "string")
+	}
+	fmt.Fprintf(&types, "export type %sColumn = %s;\n\n", name, strings.Join(columns, " | "))
+	fmt.Fprintf(&types, "export type %s = %s;\n\n", name, tsObject(allRequired(c.modelSchema()), ""))
+	fmt.Fprintf(&types, "export type %sInput = %s;\n\n", name, tsObject(c.inputSchema(false), ""))
+	fmt.Fprintf(&types, "export type %sListItem = %s;\n\n", name, tsObject(c.listItemSchema(), ""))
+	list := c.listSchema()
+	list.Required = []string{"data", "page_size"}
+	fmt.Fprintf(&types, "export type %sList = %s;\n\n", name, tsObject(list, ""))
+
+	// 自定义返回内容生成单独的类型
+	resp := func(r respItem, suffix string) string {
+		s := c.respSchema(r)
+		if s == nil {
+			return name
+		}
+		fmt.Fprintf(&types, "export type %s%sResponse = %s;\n\n", name, suffix, tsType(allRequired(s), ""))
+		return name + suffix + "Response"
+	}
+	singleResp, postResp, putResp := resp(c.singleResp, "Single"), resp(c.postResp, "Create"), resp(c.putResp, "Update")
+	deleteResp := "{ id: number }"
+	if c.deleteResp.Has {
+		deleteResp = resp(c.deleteResp, "Delete")
+	}
+	path := c.info.FullPath
+	methods := c.getMethods()
+	var client strings.Builder
+	fmt.Fprintf(&client, "    %s: {\n", tsLowerName(c.info.MapName))
+	fn := func(format string, args ...interface{}) {
+		fmt.Fprintf(&client, "      "+format+"\n", args...)
+	}
+	if isContain(methods, "get(all)") {
+		fn("list: (params?: ListParams<%sColumn>) => request<%sList>(options, \"GET\", %q, toQuery(params)),", name, name, path)
+	}
+	if isContain(methods, "get(single)") {
+		fn("get: (id: number) => request<%s>(options, \"GET\", %q + id),", singleResp, path+"/")
+	}
+	if isContain(methods, "post") {
+		fn("create: (data: %sInput) => request<%s>(options, \"POST\", %q, undefined, data),", name, postResp, path)
+	}
+	if isContain(methods, "post(bulk)") {
+		fn("bulkCreate: (data: %sInput[]) => request<BulkResponse<%s>>(options, \"POST\", %q, undefined, data),", name, postResp, path+"/_bulk")
+	}
+	if isContain(methods, "put") {
+		fn("update: (id: number, data: %sInput) => request<%s>(options, \"PUT\", %q + id, undefined, data),", name, putResp, path+"/")
+	}
+	if isContain(methods, "patch") {
+		fn("patch: (id: number, data: Partial<%sInput>) => request<%s>(options, \"PATCH\", %q + id, undefined, data),", name, putResp, path+"/")
+		if c.AllowBulkEdit {
+			fn("bulkPatch: (params: BulkParams<%sColumn>, data: Partial<%sInput>) => request<{ affected: number }>(options, \"PATCH\", %q, toQuery(params), data),", name, name, path)
+		}
+	}
+	if isContain(methods, "delete") {
+		fn("delete: (id: number) => request<%s>(options, \"DELETE\", %q + id),", deleteResp, path+"/")
+		if c.AllowBulkDelete {
+			fn("bulkDelete: (params: BulkParams<%sColumn>) => request<{ affected: number }>(options, \"DELETE\", %q, toQuery(params)),", name, path)
+		}
+	}
+	client.WriteString("    },\n")
+	return types.String(), client.String()
+}
+
+// TypeScript 根据注册的模型生成typescript类型与请求客户端
+// 每个模型生成 模型 Input ListItem List Column 类型 createClient返回按模型分组的请求方法
+func (c *RestApi) TypeScript() string {
+	var b strings.Builder
+	b.WriteString("// Code generated by ab. DO NOT EDIT.\n\n")
+	b.WriteString(tsRuntime)
+	clients := make([]string, 0, len(c.C.Models))
+	for _, model := range c.C.Models {
+		types, client := model.modelTypeScript()
+		b.WriteString("\n" + types)
+		clients = append(clients, client)
+	}
+	b.WriteString("export function createClient(options: ClientOptions = {}) {\n  return {\n")
+	for _, client := range clients {
+		b.WriteString(client)
+	}
+	b.WriteString("  };\n}\n")
+	return b.String()
+}
+
+// ExportTypeScript 导出typescript到文件
+func (c *RestApi) ExportTypeScript(fileName string) error {
+	return ioutil.WriteFile(fileName, []byte(c.TypeScript()), 0644)
+}